@@ -1,837 +1,837 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"go/ast"
-	"go/parser"
 	"go/token"
-	"go/types"
+	"io"
 	"log"
+	"maps"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-	"text/template"
+	"time"
 
-	"golang.org/x/tools/go/packages"
+	"github.com/ojxio/duck-impl/duckimpl"
 )
 
-type Method struct {
-	MethodName string
-	Parameters []string        // paramName paramType
-	Results    []string        // resName resType
-	Imports    map[string]bool // stored imports used in the method by paramType and resType
-}
-
-type Generator struct {
-	StructName    string
-	InterfaceName string
-	OutputFile    string
-	PackageName   string
-	Methods       []Method
-	Imports       []string // deduplicated list of imports
-}
-
-var debugLog func(string, ...interface{})
-
 func main() {
 	// Parse command line flags
 	structName := flag.String("struct", "", "Name of the struct to hold the implementations of the interface")
 	interfaceName := flag.String("interface", "", "Name of the interface to implement")
+	methodsFrom := flag.String("methodsFrom", "", "Comma-separated interfaces whose method sets are merged into one struct, instead of -interface")
 	outputFile := flag.String("outputFile", "ducktypes.gen.go", "Output file name")
-	debug := flag.Bool("debug", false, "Enable debug logging")
+	flag.StringVar(structName, "s", "", "Alias for -struct")
+	flag.StringVar(interfaceName, "i", "", "Alias for -interface")
+	flag.StringVar(outputFile, "o", "ducktypes.gen.go", "Alias for -outputFile")
+	outputDir := flag.String("outDir", "", "Write <interface>_impl.go, named after the lowercased interface, into this directory instead of outputFile")
+	appendFlag := flag.Bool("append", false, "Append the generated declarations to outputFile, an existing file of the package, merging their imports into it")
+	force := flag.Bool("force", false, "Overwrite outputFile even if it is not a generated file")
+	tests := flag.Bool("tests", false, "Also search the _test.go files of the source directory for the interface")
+	pattern := flag.String("pattern", "", "Package pattern, like ./..., searched for unqualified interfaces instead of the source directory only")
+	srcDir := flag.String("srcdir", "", "Directory to parse the interface from (default: working directory)")
+	format := flag.String("format", "go", "Output format: go (generate code) or json (dump parsed methods to stdout)")
+	packageName := flag.String("package", "", "Package clause of the generated file (default: detected from the output file's directory)")
+	templateFile := flag.String("template", "", "text/template file replacing the built-in code template")
+	buildTags := flag.String("buildTags", "", "Build constraint expression, like integration, emitted as a //go:build line")
+	header := flag.String("header", "", "File whose contents, or literal text, replace the generated file header")
+	receiver := flag.String("receiver", "", "Receiver name used in generated methods (default: <lowercased interface>_impl)")
+	embedded := flag.String("embedded", duckimpl.EmbeddedFlatten, "How to handle methods of embedded interfaces: flatten, skip or delegate")
+	sortMethods := flag.Bool("sort", true, "Sort generated fields and methods by method name")
+	groupByEmbedded := flag.Bool("groupByEmbedded", false, "Group generated methods under a comment per embedded interface they come from")
+	verify := flag.Bool("verify", false, "Generate a Verify(t testing.TB) method reporting unimplemented methods (requires a _test.go outputFile)")
+	futureProof := flag.Bool("futureProof", false, "Embed the interface in the struct so it keeps satisfying it when methods are added")
+	fallback := flag.Bool("fallback", false, "Embed the interface in the struct and forward the methods whose function field is nil to it")
+	zeroStub := flag.Bool("zeroStub", false, "Return zero values from methods whose function field is nil instead of panicking")
+	namedStruct := flag.Bool("namedStruct", false, "Declare the struct under its own name instead of as an alias of _<interface>_")
+	spy := flag.Bool("spy", false, "Record the arguments of every call in an exported <Method>Calls field; methods get pointer receivers")
+	threadSafe := flag.Bool("threadSafe", false, "Serialize calls to the generated methods with a mutex; implies -namedStruct, and methods get pointer receivers")
+	ptrReceiver := flag.Bool("ptrReceiver", false, "Give generated methods pointer receivers; implies -namedStruct")
+	options := flag.Bool("options", false, "Generate With<Method> functional options and a New(opts ...Option) constructor")
+	diff := flag.Bool("diff", false, "Print a unified diff between outputFile and the generated code instead of writing it")
+	decorator := flag.Bool("decorator", false, "Generate <struct>Logger, which logs every call to an implementation of the interface, instead of the function-field struct")
+	aliasImports := flag.Bool("aliasImports", false, "Alias imports whose name is declared by the output package, like httpx for net/http")
+	only := flag.String("only", "", "Comma-separated methods to generate, leaving out the others")
+	exclude := flag.String("exclude", "", "Comma-separated methods to leave out of the generated struct")
+	fieldSuffix := flag.String("fieldSuffix", "", "Suffix appended to the lowercased method names to name the function fields, like readFn for Fn")
+	localPrefix := flag.String("local", "", "Comma-separated import path prefixes grouped after third-party imports, like goimports -local")
+	gofmt := flag.Bool("gofmt", false, "Format the generated code with gofmt, failing if it is not valid Go")
+	timing := flag.Bool("timing", false, "Generate <struct>Timing, which reports the duration of every call to an implementation of the interface, instead of the function-field struct")
+	watchFlag := flag.Bool("watch", false, "Keep running and regenerate outputFile whenever a .go file in the source directory changes")
+	wrapErrors := flag.Bool("wrapErrors", false, "Under decorator or timing, wrap errors returned last as \"<struct>.<method>: %w\"")
+	funcAdapter := flag.Bool("funcAdapter", false, "Generate <struct>Func, a func type implementing a single-method interface by calling itself, like http.HandlerFunc")
+	unimplemented := flag.Bool("unimplemented", false, "Generate Unimplemented<struct>, an embeddable base whose methods panic, instead of the function-field struct")
+	list := flag.Bool("list", false, "Print the resolved methods of the interface, one per line, instead of generating code")
+	check := flag.Bool("check", false, "Exit non-zero, printing a unified diff to stderr, when outputFile is not up to date, without writing it")
+	preview := flag.Bool("preview", false, "Print the generated code to stdout instead of writing outputFile")
+	exportData := flag.String("exportData", "", "Load the interface's package from compiled export data at this path instead of source")
+	sourceFile := flag.String("file", "", "Parse the interface from this single .go file, bypassing package and module resolution")
+	traceFile := flag.String("trace", "", "Write a JSON-lines trace of the interface resolution steps to this file")
+	debug := flag.Bool("debug", false, "Enable debug logging, like -logLevel debug")
+	logLevel := flag.String("logLevel", "error", "Messages logged to stderr: error, info (warnings and resolution steps) or debug")
+	verbose := flag.Bool("v", false, "Print a summary of what was generated to stderr")
+
+	// kebab-case spellings of the multi-word flags
+	flag.BoolVar(zeroStub, "zero-stub", false, "Alias for -zeroStub")
+	flag.StringVar(buildTags, "build-tags", "", "Alias for -buildTags")
+	flag.BoolVar(threadSafe, "thread-safe", false, "Alias for -threadSafe")
+	flag.BoolVar(namedStruct, "named-struct", false, "Alias for -namedStruct")
+	flag.BoolVar(ptrReceiver, "ptr-receiver", false, "Alias for -ptrReceiver")
+	flag.StringVar(fieldSuffix, "field-suffix", "", "Alias for -fieldSuffix")
+	flag.StringVar(outputDir, "out-dir", "", "Alias for -outDir")
+	flag.StringVar(methodsFrom, "methods-from", "", "Alias for -methodsFrom")
+	flag.BoolVar(funcAdapter, "func-adapter", false, "Alias for -funcAdapter")
+	flag.BoolVar(wrapErrors, "wrap-errors", false, "Alias for -wrapErrors")
+	flag.StringVar(logLevel, "log-level", "error", "Alias for -logLevel")
 	flag.Parse()
 
-	if *structName == "" || *interfaceName == "" || *outputFile == "" {
-		log.Fatal("struct, interface and outputFile flags are required")
+	if *outputDir != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "outputFile" || f.Name == "o" {
+				log.Fatal("outDir cannot be combined with outputFile")
+			}
+		})
+		// -methodsFrom names the merged interface after the struct
+		name, _ := splitTypeArgs(*interfaceName)
+		if *methodsFrom != "" {
+			name, _ = splitTypeParams(*structName)
+		}
+		*outputFile = filepath.Join(*outputDir, strings.ToLower(cleanName(name))+"_impl.go")
 	}
 
-	debugLog = func(format string, args ...interface{}) {
-		if *debug {
-			fmt.Printf(format, args...)
+	switch *format {
+	case "go":
+		if *list && *interfaceName == "" && *methodsFrom == "" {
+			log.Fatal("interface flag is required")
+		}
+		if !*list && (*structName == "" || (*interfaceName == "" && *methodsFrom == "") || *outputFile == "") {
+			log.Fatal("struct, interface and outputFile flags are required")
+		}
+	case "json":
+		if *interfaceName == "" && *methodsFrom == "" {
+			log.Fatal("interface flag is required")
 		}
+	default:
+		log.Fatalf("unknown format %q, expected go or json", *format)
 	}
 
-	// Get current working directory
-	dir, err := os.Getwd()
-	if err != nil {
-		log.Fatalf("Failed to get current directory: %v", err)
+	if *zeroStub && *futureProof {
+		log.Fatal("zeroStub cannot be combined with futureProof")
 	}
 
-	// Parse the Go files in the current directory
-	methods, _, err := parseInterface(dir, *interfaceName)
-	if err != nil {
-		log.Fatalf("Failed to parse interface: %v", err)
+	if *fallback && (*zeroStub || *futureProof) {
+		log.Fatal("fallback cannot be combined with zeroStub or futureProof")
 	}
 
-	// get current pkg
-	var currentPkg string
-	// Parse the current directory to get the package name
-	if fset := token.NewFileSet(); fset != nil {
-		pkgs, err := parser.ParseDir(fset, dir, nil, parser.PackageClauseOnly)
-		if err == nil {
-			for pkgName := range pkgs {
-				currentPkg = pkgName
-			}
+	if *methodsFrom != "" {
+		if *interfaceName != "" {
+			log.Fatal("methodsFrom cannot be combined with interface")
 		}
-	}
-
-	imports := make([]string, 0)
-	// process imports
-	for _, method := range methods {
-		for imp, in_use := range method.Imports {
-			if in_use {
-				imports = append(imports, imp)
-			}
+		if *futureProof || *fallback {
+			log.Fatal("futureProof and fallback cannot be combined with methodsFrom")
 		}
 	}
 
-	// Generate code
-	generator := Generator{
-		StructName:    *structName,
-		InterfaceName: *interfaceName,
-		OutputFile:    *outputFile,
-		PackageName:   currentPkg,
-		Methods:       methods,
-		Imports:       imports,
+	switch *embedded {
+	case duckimpl.EmbeddedFlatten, duckimpl.EmbeddedSkip, duckimpl.EmbeddedDelegate:
+	default:
+		log.Fatalf("unknown embedded mode %q, expected flatten, skip or delegate", *embedded)
 	}
 
-	if err := generator.Generate(); err != nil {
-		log.Fatalf("Failed to generate code: %v", err)
+	if *verify && !strings.HasSuffix(*outputFile, "_test.go") {
+		log.Fatal("verify requires the outputFile to be a _test.go file")
 	}
-}
 
-func SplitRight(s, sep string) []string {
-	idx := strings.LastIndex(s, sep)
-	if idx == -1 {
-		return []string{s} // separator not found
+	if *watchFlag && (*format != "go" || *list || *preview || *diff || *check) {
+		log.Fatal("watch only applies when writing outputFile")
 	}
-	return []string{s[:idx], s[idx+len(sep):]}
-}
 
-func parseInterface(dir, interfaceName string) ([]Method, string, error) {
-	// Handle potentially qualified interface name (package.Interface)
-	var pkgPath, intName string
-	parts := SplitRight(interfaceName, ".")
-	if len(parts) > 1 {
-		pkgPath = parts[0]
-		intName = parts[len(parts)-1] // Use the last part as the interface name
-	} else {
-		intName = interfaceName
+	modes := 0
+	for _, mode := range []bool{*decorator, *timing, *unimplemented, *funcAdapter} {
+		if mode {
+			modes++
+		}
+	}
+	if modes > 1 {
+		log.Fatal("only one of decorator, timing, unimplemented and funcAdapter can be set")
 	}
 
-	debugLog("Looking for interface: package=%s, name=%s\n", pkgPath, intName)
-
-	// First, try using the go/packages approach (preferred)
-	methods, hostPkgName, err := parseInterfaceWithTypes(dir, pkgPath, intName, interfaceName)
-	if err == nil {
-		return methods, hostPkgName, nil
+	if *appendFlag && (*watchFlag || *check || *diff || *buildTags != "") {
+		log.Fatal("append cannot be combined with watch, check, diff or buildTags")
 	}
 
-	debugLog("go/packages approach failed: %v\n", err)
-	debugLog("Falling back to AST-based approach\n")
+	if *wrapErrors && !*decorator && !*timing {
+		log.Fatal("wrapErrors requires decorator or timing")
+	}
 
-	// Fall back to the AST-based approach
-	return parseInterfaceWithAST(dir, pkgPath, intName, interfaceName)
-}
+	if *fieldSuffix != "" && !token.IsIdentifier("x"+*fieldSuffix) {
+		log.Fatalf("Invalid field suffix %q: it must continue an identifier", *fieldSuffix)
+	}
 
-// parseInterfaceWithTypes uses the go/packages and go/types packages to load and analyze interfaces
-func parseInterfaceWithTypes(dir, pkgPath, intName, fullInterfaceName string) ([]Method, string, error) {
-	var importPath string
+	if *sourceFile != "" && (*exportData != "" || *tests) {
+		log.Fatal("file cannot be combined with exportData or tests")
+	}
 
-	if pkgPath == "" {
-		// For interfaces in the current package, we need to determine the import path
-		cmd := exec.Command("go", "list", "-f", "{{.ImportPath}}")
-		cmd.Dir = dir // Set working directory for the command
-		output, err := cmd.Output()
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to determine current package import path: %v", err)
-		}
-		importPath = strings.TrimSpace(string(output))
-	} else {
-		// Extract the actual import path from the package path
-		// For paths like "github.com/user/repo/path/to/module.Interface",
-		// we need to determine the module path (could be repo or repo/path/to/module)
-		importPath = pkgPath
-
-		// Try to find the base module path by iteratively trying shorter paths
-		components := strings.Split(pkgPath, "/")
-		for i := len(components); i > 0; i-- {
-			partialPath := strings.Join(components[:i], "/")
-			if isValidModule(partialPath) {
-				importPath = partialPath
-				debugLog("Found valid module: %s\n", importPath)
-				break
-			}
-		}
+	if *pattern != "" && (*sourceFile != "" || *exportData != "") {
+		log.Fatal("pattern cannot be combined with file or exportData")
 	}
 
-	debugLog("Loading package: %s\n", importPath)
+	flag.Visit(func(f *flag.Flag) {
+		if (f.Name == "buildTags" || f.Name == "build-tags") && strings.TrimSpace(*buildTags) == "" {
+			log.Fatal("buildTags must not be empty")
+		}
+	})
 
-	// Configure the packages.Load
-	cfg := &packages.Config{
-		Mode:  packages.NeedName | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedSyntax,
-		Dir:   dir, // Set the working directory
-		Tests: false,
+	if *packageName != "" && !token.IsIdentifier(*packageName) {
+		log.Fatalf("package %q is not a valid Go identifier", *packageName)
 	}
 
-	pkgs, err := packages.Load(cfg, importPath)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to load package %s: %v", importPath, err)
+	if *receiver != "" {
+		if !token.IsIdentifier(*receiver) {
+			log.Fatalf("receiver %q is not a valid Go identifier", *receiver)
+		}
+		if token.IsKeyword(*receiver) {
+			log.Fatalf("receiver %q is a reserved Go keyword", *receiver)
+		}
 	}
 
-	if len(pkgs) == 0 {
-		return nil, "", fmt.Errorf("no packages found for %s", importPath)
+	// Log to stderr, so that nothing but the requested output reaches stdout
+	level := *logLevel
+	if *debug {
+		level = "debug"
+	}
+	logger := log.New(os.Stderr, "", 0)
+	switch level {
+	case "debug":
+		duckimpl.DebugLog = logger.Printf
+		duckimpl.InfoLog = logger.Printf
+	case "info":
+		duckimpl.InfoLog = logger.Printf
+	case "error":
+	default:
+		log.Fatalf("unknown log level %q, expected error, info or debug", level)
 	}
 
-	// Check for load errors
-	var errs []string
-	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
-		for _, err := range pkg.Errors {
-			errs = append(errs, err.Error())
+	if *traceFile != "" {
+		file, err := os.Create(*traceFile)
+		if err != nil {
+			fatal(err, "Failed to create trace file")
 		}
-	})
+		defer file.Close()
 
-	if len(errs) > 0 {
-		return nil, "", fmt.Errorf("errors loading packages: %s", strings.Join(errs, "; "))
+		enc := json.NewEncoder(file)
+		duckimpl.Trace = func(step string, details map[string]interface{}) {
+			record := map[string]interface{}{"step": step, "time": time.Now().Format(time.RFC3339Nano)}
+			for key, value := range details {
+				record[key] = value
+			}
+			// tracing is best effort and never fails the run
+			_ = enc.Encode(record)
+		}
 	}
 
-	pkg := pkgs[0]
-	debugLog("Package loaded: %s\n", pkg.Name)
+	// Get current working directory
+	dir, err := os.Getwd()
+	if err != nil {
+		fatal(err, "Failed to get current directory")
+	}
 
-	// Look up the interface type
-	obj := pkg.Types.Scope().Lookup(intName)
-	if obj == nil {
-		// If not found directly, try to search in imported packages
-		for _, imported := range pkg.Imports {
-			obj = imported.Types.Scope().Lookup(intName)
-			if obj != nil {
-				pkg = imported // Use the package where the interface was found
-				break
-			}
+	// Parse the interface from -srcdir, resolved against the working directory
+	parseDir := dir
+	if *srcDir != "" {
+		parseDir = *srcDir
+		if !filepath.IsAbs(parseDir) {
+			parseDir = filepath.Join(dir, parseDir)
 		}
 	}
 
-	if obj == nil {
-		return nil, "", fmt.Errorf("interface %s not found in package %s", intName, importPath)
+	// the output file may live in another directory, or package, than the interface
+	outDir := filepath.Dir(*outputFile)
+	if !filepath.IsAbs(outDir) {
+		outDir = filepath.Join(dir, outDir)
 	}
 
-	// Verify it's an interface type
-	named, ok := obj.Type().(*types.Named)
-	if !ok {
-		return nil, "", fmt.Errorf("%s is not a named type", intName)
+	// a -srcdir package other than the output package is imported, like any
+	// other, so the local interfaces it declares are qualified by its path
+	var srcImportPath, outImportPath string
+	if *exportData == "" && *sourceFile == "" {
+		outImportPath, _ = duckimpl.PackageImportPath(outDir)
+	}
+	if outImportPath != "" && filepath.Clean(outDir) != filepath.Clean(parseDir) {
+		if srcPath, err := duckimpl.PackageImportPath(parseDir); err == nil && srcPath != outImportPath {
+			srcImportPath = srcPath
+		}
 	}
 
-	iface, ok := named.Underlying().(*types.Interface)
-	if !ok {
-		return nil, "", fmt.Errorf("%s is not an interface type", intName)
+	if *watchFlag {
+		watchDir := parseDir
+		if *sourceFile != "" {
+			watchDir = filepath.Dir(*sourceFile)
+		}
+		watch(watchDir, *outputFile)
+		return
 	}
 
-	debugLog("Found interface %s in package %s\n", intName, pkg.Name)
+	// Parse the Go files in the source directory
+	parseSource := func(name string) (duckimpl.Interface, error) {
+		if *exportData != "" {
+			return duckimpl.ParseInterfaceFromExportData(*exportData, name)
+		}
+		if *sourceFile != "" {
+			return duckimpl.ParseInterfaceFromFile(*sourceFile, name)
+		}
+		if *tests {
+			return duckimpl.ParseTestInterface(parseDir, name)
+		}
+		return duckimpl.ParseInterface(parseDir, name)
+	}
+	parse := func(name string) (duckimpl.Interface, error) {
+		iface, err := parseSource(name)
+		if err != nil || !strings.Contains(name, ".") {
+			return iface, err
+		}
+		// only the package declaring an interface can implement its unexported methods
+		for _, method := range iface.Methods {
+			if !token.IsExported(method.MethodName) {
+				return duckimpl.Interface{}, fmt.Errorf("%s has the unexported method %s, which cannot be implemented outside its package", name, method.MethodName)
+			}
+		}
+		return iface, nil
+	}
 
-	// Extract methods from the interface
-	var methods []Method
-	for i := 0; i < iface.NumMethods(); i++ {
-		meth := iface.Method(i)
-		sig := meth.Type().(*types.Signature)
+	// -struct may rename the type parameters of a generic interface, as in MyStore[K, V]
+	structBase, typeParamNames := splitTypeParams(*structName)
 
-		method := Method{
-			MethodName: meth.Name(),
+	// -pattern resolves unqualified interfaces among the packages it matches;
+	// without it, those of another -srcdir package are qualified by its path
+	qualify := func(name string) string {
+		if name == "" || strings.Contains(name, ".") {
+			return name
 		}
-
-		// collect imports from interface's methods
-		imports := make(map[string]bool)
-		// Process parameters
-		for j := range sig.Params().Len() {
-			param := sig.Params().At(j)
-			for _, import_path := range param.Pkg().Imports() {
-				path := import_path.Path()
-				imports[path] = strings.Contains(param.Origin().String(), path)
+		if *pattern == "" {
+			if srcImportPath != "" {
+				return srcImportPath + "." + name
 			}
-			paramTypeStr := types.TypeString(param.Type(), func(p *types.Package) string { return p.Name() })
-
-			// Handle variadic parameters
-			if sig.Variadic() && j == sig.Params().Len()-1 {
-				slice, ok := param.Type().(*types.Slice)
-				if ok {
-					elemTypeStr := types.TypeString(slice.Elem(), func(p *types.Package) string { return "" })
-					paramTypeStr = "..." + elemTypeStr
-				}
+			return name
+		}
+		qualified, err := duckimpl.FindInterface(parseDir, *pattern, name)
+		if err != nil {
+			fatal(err, "Failed to find interface %s", name)
+		}
+		// an interface of the output package stays local, as importing it would be a cycle
+		if pkgPath := duckimpl.SplitRight(qualified, ".")[0]; pkgPath == outImportPath && srcImportPath == "" {
+			return name
+		}
+		return qualified
+	}
+
+	// -interface may instantiate a generic interface, as in Container[int]
+	interfaceBase, typeArgs := splitTypeArgs(*interfaceName)
+	interfaceBase = qualify(interfaceBase)
+
+	// -methodsFrom merges several interfaces into one named after the struct
+	targetName := interfaceBase
+	var iface duckimpl.Interface
+	var sources []duckimpl.Interface
+	if *methodsFrom != "" {
+		for _, name := range strings.Split(*methodsFrom, ",") {
+			source, err := parse(qualify(strings.TrimSpace(name)))
+			if err != nil {
+				fatal(err, "Failed to parse interface %s", name)
 			}
+			sources = append(sources, source)
+		}
 
-			paramName := param.Name()
-			if paramName == "" {
-				// If the parameter has no name, use a generic name
-				paramName = fmt.Sprintf("arg%d", j)
-			}
+		targetName = structBase
+		iface, err = duckimpl.MergeInterfaces(targetName, sources...)
+		if err != nil {
+			fatal(err, "Failed to merge interfaces")
+		}
+	} else {
+		iface, err = parse(interfaceBase)
+		if err != nil {
+			fatal(err, "Failed to parse interface")
+		}
+	}
 
-			method.Parameters = append(method.Parameters, fmt.Sprintf("%s %s", paramName, paramTypeStr))
+	if typeArgs != nil {
+		iface, err = iface.Instantiate(parseDir, typeArgs)
+		if err != nil {
+			fatal(err, "Invalid interface type arguments")
 		}
+	}
 
-		// Process return values
-		for j := range sig.Results().Len() {
-			result := sig.Results().At(j)
-			for _, import_path := range result.Pkg().Imports() {
-				path := import_path.Path()
-				imports[path] = strings.Contains(result.Origin().String(), path)
-			}
+	if typeParamNames != nil {
+		iface, err = iface.RenameTypeParams(typeParamNames)
+		if err != nil {
+			fatal(err, "Invalid struct type parameters")
+		}
+	}
+	methods := iface.Methods
 
-			resultTypeStr := types.TypeString(result.Type(), func(p *types.Package) string { return p.Name() })
+	if *format == "json" {
+		if err := writeMethodsJSON(os.Stdout, targetName, iface.Package, methods); err != nil {
+			fatal(err, "Failed to write JSON")
+		}
+		return
+	}
 
-			resultName := result.Name()
-			if resultName == "" {
-				// If the result has no name, just use the type
-				method.Results = append(method.Results, resultTypeStr)
-			} else {
-				method.Results = append(method.Results, fmt.Sprintf("%s %s", resultName, resultTypeStr))
+	if *list {
+		if *sortMethods {
+			duckimpl.SortMethods(methods)
+		}
+		for _, method := range methods {
+			if method.Embedded != "" {
+				fmt.Printf("%s\t// from %s\n", method, method.Embedded)
+				continue
 			}
-
-			method.Imports = imports
+			fmt.Println(method)
 		}
+		return
+	}
 
-		methods = append(methods, method)
+	// get the package of the output file, which may live in another directory
+	currentPkg := *packageName
+	if currentPkg == "" && *tests && !strings.Contains(targetName, ".") && filepath.Clean(outDir) == filepath.Clean(parseDir) {
+		// generate alongside a local test interface, possibly in the _test package
+		currentPkg = iface.Package
+	}
+	if currentPkg == "" && *sourceFile != "" {
+		// the file need not belong to a package the output directory agrees with
+		currentPkg = iface.Package
+	}
+	if currentPkg == "" {
+		currentPkg, err = duckimpl.DetectPackageName(outDir)
+		if err != nil {
+			fatal(fmt.Errorf("%w (set it with -package)", err), "Failed to detect package name")
+		}
 	}
 
-	return methods, pkg.Name, nil
-}
+	// read the custom header, or record the invoking command in the default one
+	headerText := "// Code generated by duck-impl; DO NOT EDIT.\n// " + commandLine()
+	if *header != "" {
+		headerText = *header
+		if !strings.HasPrefix(*header, "//") && !strings.HasPrefix(*header, "/*") {
+			content, err := os.ReadFile(*header)
+			switch {
+			case err == nil:
+				headerText = string(content)
+			case !os.IsNotExist(err) || looksLikePath(*header):
+				// a mistyped or unreadable file must not end up in the header
+				fatal(err, "Failed to read header")
+			}
+		}
+	}
 
-// isValidModule checks if the given import path is a valid Go module
-func isValidModule(importPath string) bool {
-	cmd := exec.Command("go", "list", "-f", "{{.Dir}}", importPath)
-	if err := cmd.Run(); err != nil {
-		return false
+	var templateText string
+	if *templateFile != "" {
+		content, err := os.ReadFile(*templateFile)
+		if err != nil {
+			fatal(err, "Failed to read template")
+		}
+		templateText = string(content)
 	}
-	return true
-}
 
-func findModulePath(importPath string) (string, error) {
-	cmd := exec.Command("go", "list", "-f", importPath)
-	output, err := cmd.Output()
-	if err != nil {
-		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) {
-			return "", fmt.Errorf("go list failed: %s", exitErr.Stderr)
+	// keep only the methods selected by -only and -exclude
+	partial := false
+	if *only != "" || *exclude != "" {
+		filtered, err := duckimpl.FilterMethods(methods, splitNames(*only), splitNames(*exclude))
+		if err != nil {
+			fatal(err, "Invalid method filter")
 		}
-		return "", fmt.Errorf("failed to execute go list: %v", err)
+		partial = len(filtered) < len(methods)
+		methods = filtered
 	}
-	debugLog("Found module path: %s\n", string(output))
-	return strings.TrimSpace(string(output)), nil
-}
 
-// parseInterfaceWithAST is the original AST-based approach as a fallback
-func parseInterfaceWithAST(dir, pkgPath, intName, fullInterfaceName string) ([]Method, string, error) {
-	fset := token.NewFileSet()
+	// drop or delegate methods promoted from embedded interfaces
+	embeddedMode := *embedded
+	if *decorator || *timing || *unimplemented || *funcAdapter {
+		// these implement every method of the interface
+		embeddedMode = duckimpl.EmbeddedFlatten
+	}
+	methods, delegates, delegateImports := duckimpl.ApplyEmbeddedMode(methods, embeddedMode)
+	if *sortMethods {
+		duckimpl.SortMethods(methods)
+	}
 
-	// Parse the package
-	pkgs, err := parser.ParseDir(fset, dir, nil, parser.ParseComments)
-	if err != nil {
-		return nil, "", fmt.Errorf("could not parse directory: %v", err)
-	}
-
-	var interfaceType *ast.InterfaceType
-	var hostPkgName string
-	var stdPkgs map[string]*ast.Package
-
-	if pkgPath != "" {
-		// Determine the full import path for the package
-		importPath := pkgPath
-
-		debugLog("Attempting to load package: %s\n", importPath)
-
-		// First try standard library
-		goRoot := runtime.GOROOT()
-		stdLibPath := filepath.Join(goRoot, "src", strings.Replace(importPath, ".", "/", -1))
-
-		debugLog("Searching in standard library path: %s\n", stdLibPath)
-
-		if _, err := os.Stat(stdLibPath); err == nil {
-			// Parse the standard library package
-			stdPkgs, err = parser.ParseDir(fset, stdLibPath, nil, parser.ParseComments)
-			if err == nil {
-				for stdPkgName, stdPkg := range stdPkgs {
-					debugLog("Found standard package: %s\n", stdPkgName)
-					hostPkgName = stdPkgName
-
-					// Look for the interface in the standard package
-					for _, file := range stdPkg.Files {
-						ast.Inspect(file, func(n ast.Node) bool {
-							typeSpec, ok := n.(*ast.TypeSpec)
-							if !ok || typeSpec.Name.Name != intName {
-								return true
-							}
-
-							iface, ok := typeSpec.Type.(*ast.InterfaceType)
-							if !ok {
-								return true
-							}
-
-							debugLog("Found interface %s in standard library\n", intName)
-							interfaceType = iface
-							return false
-						})
-
-						if interfaceType != nil {
-							break
-						}
-					}
-
-					if interfaceType != nil {
-						break
-					}
-				}
-			}
+	// process imports
+	if delegateImports == nil {
+		delegateImports = make(map[string]string)
+	}
+	for path, name := range iface.TypeParamImports {
+		delegateImports[path] = name
+	}
+	if *verify {
+		delegateImports["testing"] = "testing"
+	}
+	if *threadSafe {
+		delegateImports["sync"] = "sync"
+	}
+	interfaceType := cleanName(targetName)
+	if *futureProof || *fallback || *decorator || *timing {
+		interfaceType = interfaceRef(iface, methods, delegateImports)
+		if typeArgs != nil {
+			interfaceType += "[" + strings.Join(typeArgs, ", ") + "]"
 		}
+	}
+	if *decorator {
+		delegateImports["log"] = "log"
+	}
+	if *wrapErrors && slices.ContainsFunc(methods, func(method duckimpl.Method) bool {
+		return len(method.Returns) > 0 && method.Returns[len(method.Returns)-1].Type == "error"
+	}) {
+		delegateImports["fmt"] = "fmt"
+	}
+	if *timing {
+		delegateImports["time"] = "time"
+	}
+	var implements []string
+	if partial && len(sources) > 0 {
+		log.Printf("Warning: methods were filtered out, so the struct no longer implements %s; omitting the compile-time assertions", *methodsFrom)
+	} else {
+		for _, source := range sources {
+			implements = append(implements, interfaceRef(source, methods, delegateImports))
+		}
+	}
+	imports := duckimpl.CollectImports(methods, delegateImports)
 
-		// If not found in standard library, try to find module root first
-		if interfaceType == nil {
-			// Try to find the base module path by iteratively trying shorter paths
-			debugLog("let's try to find the module path by iteratively trying shorter paths\n")
-			components := strings.Split(pkgPath, "/")
-			var modulePath string
-
-			for i := len(components); i > 0; i-- {
-				partialPath := strings.Join(components[:i], "/")
-				path, err := findModulePath(partialPath)
-				debugLog("path: %s, err: %v\n", path, err)
-				if err == nil && path != "" {
-					modulePath = path
-					// If we found a valid module but need to access a subpackage
-					if i < len(components) {
-						modulePath = filepath.Join(modulePath, strings.Join(components[i:], "/"))
-					}
-					debugLog("Found module root: %s, full path: %s\n", partialPath, modulePath)
-					break
-				}
-			}
+	// Generate code
+	generator := duckimpl.Generator{
+		StructName:      structBase,
+		InterfaceName:   targetName,
+		OutputFile:      *outputFile,
+		Force:           *force,
+		PackageName:     currentPkg,
+		Header:          headerText,
+		BuildTags:       *buildTags,
+		Template:        templateText,
+		FieldSuffix:     *fieldSuffix,
+		LocalPrefix:     *localPrefix,
+		Gofmt:           *gofmt,
+		FutureProof:     *futureProof,
+		Fallback:        *fallback,
+		ZeroStub:        *zeroStub,
+		NamedStruct:     *namedStruct || *ptrReceiver || *threadSafe,
+		Spy:             *spy,
+		ThreadSafe:      *threadSafe,
+		PtrReceiver:     *ptrReceiver,
+		Options:         *options,
+		Decorator:       *decorator,
+		Timing:          *timing,
+		WrapErrors:      *wrapErrors,
+		Unimplemented:   *unimplemented,
+		FuncAdapter:     *funcAdapter,
+		InterfaceType:   interfaceType,
+		Receiver:        *receiver,
+		TypeParams:      iface.TypeParams,
+		Methods:         methods,
+		Delegates:       delegates,
+		Verify:          *verify,
+		GroupByEmbedded: *groupByEmbedded,
+		Implements:      implements,
+		Imports:         imports,
+	}
+	if *aliasImports {
+		taken, err := duckimpl.DeclaredNames(outDir, currentPkg, *outputFile)
+		if err != nil {
+			fatal(err, "Failed to read the output package")
+		}
+		generator.AliasImports(taken)
+	}
 
-			if modulePath != "" {
-				debugLog("Found module path: %s\n", modulePath)
-
-				// Parse the module
-				modPkgs, err := parser.ParseDir(fset, modulePath, nil, parser.ParseComments)
-				if err == nil {
-					debugLog("Successfully parsed module directory\n")
-
-					for modPkgName, modPkg := range modPkgs {
-						debugLog("Examining package: %s\n", modPkgName)
-						hostPkgName = modPkgName
-
-						for fileName, file := range modPkg.Files {
-							debugLog("Examining file: %s\n", fileName)
-							ast.Inspect(file, func(n ast.Node) bool {
-								typeSpec, ok := n.(*ast.TypeSpec)
-								if !ok || typeSpec.Name.Name != intName {
-									return true
-								}
-
-								iface, ok := typeSpec.Type.(*ast.InterfaceType)
-								if !ok {
-									return true
-								}
-
-								debugLog("Found interface %s in module\n", intName)
-								interfaceType = iface
-								return false
-							})
-
-							if interfaceType != nil {
-								break
-							}
-						}
-
-						if interfaceType != nil {
-							break
-						}
-					}
-				} else {
-					debugLog("Error parsing module directory: %v\n", err)
-				}
-			} else {
-				debugLog("Could not find valid module path\n")
-			}
+	if level != "error" {
+		generator.CheckInternalImports(outDir)
+	}
 
-			// Final fallback to the old approach
-			if interfaceType == nil {
-				goPath := os.Getenv("GOPATH")
-				if goPath == "" {
-					// Default GOPATH
-					homeDir, _ := os.UserHomeDir()
-					goPath = filepath.Join(homeDir, "go")
-				}
-
-				// For third-party packages
-				possiblePaths := []string{
-					filepath.Join(goPath, "src", strings.Replace(importPath, ".", "/", -1)),
-					filepath.Join(goPath, "pkg", "mod", strings.Replace(importPath, ".", "/", -1)+"@*"), // For Go modules
-					filepath.Join(dir, "vendor", strings.Replace(importPath, ".", "/", -1)),
-				}
-
-				for _, path := range possiblePaths {
-					debugLog("Searching fallback path: %s\n", path)
-					matches, _ := filepath.Glob(path)
-
-					for _, match := range matches {
-						if stat, err := os.Stat(match); err == nil && stat.IsDir() {
-							debugLog("Found directory: %s\n", match)
-							// Parse the external package
-							extPkgs, err := parser.ParseDir(fset, match, nil, parser.ParseComments)
-							if err != nil {
-								debugLog("Error parsing directory: %v\n", err)
-								continue
-							}
-
-							// Look for the interface in the external package
-							for extPkgName, extPkg := range extPkgs {
-								debugLog("Examining package: %s\n", extPkgName)
-								hostPkgName = extPkgName
-
-								for fileName, file := range extPkg.Files {
-									debugLog("Examining file: %s\n", fileName)
-									ast.Inspect(file, func(n ast.Node) bool {
-										typeSpec, ok := n.(*ast.TypeSpec)
-										if !ok || typeSpec.Name.Name != intName {
-											return true
-										}
-
-										iface, ok := typeSpec.Type.(*ast.InterfaceType)
-										if !ok {
-											return true
-										}
-
-										debugLog("Found interface %s in external package\n", intName)
-										interfaceType = iface
-										return false
-									})
-
-									if interfaceType != nil {
-										break
-									}
-								}
-
-								if interfaceType != nil {
-									break
-								}
-							}
-
-							if interfaceType != nil {
-								break
-							}
-						}
-					}
-
-					if interfaceType != nil {
-						break
-					}
-				}
-			}
+	// -v summarizes the generated code, to confirm the right interface was picked
+	summarize := func(output string) {
+		if !*verbose {
+			return
 		}
-	} else {
-		// Look for interface in local package
-		for _, pkg := range pkgs {
-			hostPkgName = pkg.Name
-
-			for fileName, file := range pkg.Files {
-				debugLog("Examining local file: %s\n", fileName)
-				ast.Inspect(file, func(n ast.Node) bool {
-					typeSpec, ok := n.(*ast.TypeSpec)
-					if !ok || typeSpec.Name.Name != intName {
-						return true
-					}
-
-					iface, ok := typeSpec.Type.(*ast.InterfaceType)
-					if !ok {
-						return true
-					}
-
-					debugLog("Found interface %s in local package\n", intName)
-					interfaceType = iface
-					return false
-				})
-
-				if interfaceType != nil {
-					break
-				}
+		// -methodsFrom merges the interfaces of sources
+		resolvedFrom := sources
+		if len(resolvedFrom) == 0 {
+			resolvedFrom = []duckimpl.Interface{iface}
+		}
+		var resolved, via []string
+		for _, source := range resolvedFrom {
+			name := source.Name
+			if source.PkgPath != "" {
+				name += " (package " + source.PkgPath + ")"
 			}
-
-			if interfaceType != nil {
-				break
+			resolved = append(resolved, name)
+			if !slices.Contains(via, source.Via) {
+				via = append(via, source.Via)
 			}
 		}
+		noun := "methods"
+		if len(methods) == 1 {
+			noun = "method"
+		}
+		fmt.Fprintf(os.Stderr, "Generated %s implementing %s, resolved via %s: %d %s, written to %s\n",
+			*structName, strings.Join(resolved, ", "), strings.Join(via, " and "), len(methods), noun, output)
 	}
-	if interfaceType == nil {
-		return nil, "", fmt.Errorf("interface %s not found", intName)
+
+	if *preview {
+		if err := generator.Preview(os.Stdout); err != nil {
+			fatal(err, "Failed to preview code")
+		}
+		summarize("stdout")
+		return
 	}
 
-	methods := extractMethodsFromInterface(interfaceType, fset, stdPkgs)
+	if *diff || *check {
+		src, err := generator.Render()
+		if err != nil {
+			fatal(err, "Failed to generate code")
+		}
 
-	return methods, hostPkgName, nil
-}
+		// a missing output file diffs as empty
+		existing, err := os.ReadFile(*outputFile)
+		if err != nil && !os.IsNotExist(err) {
+			fatal(err, "Failed to read output file")
+		}
 
-// Modify the method extraction part:
-func extractMethodsFromInterface(iface *ast.InterfaceType, fset *token.FileSet, stdLibPkgs map[string]*ast.Package) []Method {
-	methods := make([]Method, 0)
-
-	for _, field := range iface.Methods.List {
-		// If it's a named method
-		if len(field.Names) > 0 {
-			for _, name := range field.Names {
-				funcType, ok := field.Type.(*ast.FuncType)
-				if !ok {
-					continue
-				}
-
-				foo := Method{
-					MethodName: name.Name,
-					Parameters: extractParams(funcType.Params),
-					Results:    extractParams(funcType.Results),
-				}
-				methods = append(methods, foo)
-			}
-		} else {
-			// It might be an embedded interface
-			switch fieldType := field.Type.(type) {
-			case *ast.Ident:
-				// Local embedded interface
-				embeddedMethods := findEmbeddedInterfaceMethods(fieldType.Name, nil, "", fset, stdLibPkgs)
-				methods = append(methods, embeddedMethods...)
-
-			case *ast.SelectorExpr:
-				// Embedded interface from another package
-				if pkgIdent, ok := fieldType.X.(*ast.Ident); ok {
-					embeddedMethods := findEmbeddedInterfaceMethods(fieldType.Sel.Name, pkgIdent, pkgIdent.Name, fset, stdLibPkgs)
-					methods = append(methods, embeddedMethods...)
-				}
+		if *check {
+			// compare gofmt-normalized sources, so formatting alone never fails the check
+			if d := unifiedDiff(*outputFile, *outputFile+" (generated)", gofmtSource(existing), gofmtSource(src)); d != "" {
+				fmt.Fprint(os.Stderr, d)
+				log.Fatalf("%s is not up to date, regenerate it", *outputFile)
 			}
+			return
 		}
-	}
 
-	return methods
-}
+		fmt.Print(unifiedDiff(*outputFile, *outputFile+" (generated)", existing, src))
+		return
+	}
 
-func findEmbeddedInterfaceMethods(interfaceName string, pkgIdent *ast.Ident, pkgName string, fset *token.FileSet, stdLibPkgs map[string]*ast.Package) []Method {
-	if pkgName != "" && stdLibPkgs[pkgName] != nil {
-		// Look for the embedded interface in the standard library
-		pkg := stdLibPkgs[pkgName]
-		for _, file := range pkg.Files {
-			for _, decl := range file.Decls {
-				genDecl, ok := decl.(*ast.GenDecl)
-				if !ok || genDecl.Tok != token.TYPE {
-					continue
-				}
-
-				for _, spec := range genDecl.Specs {
-					typeSpec, ok := spec.(*ast.TypeSpec)
-					if !ok || typeSpec.Name.Name != interfaceName {
-						continue
-					}
-
-					ifaceType, ok := typeSpec.Type.(*ast.InterfaceType)
-					if !ok {
-						continue
-					}
-
-					return extractMethodsFromInterface(ifaceType, fset, stdLibPkgs)
-				}
-			}
+	if *appendFlag {
+		if err := generator.Append(); err != nil {
+			fatal(err, "Failed to append code")
 		}
+		summarize(*outputFile)
+		return
 	}
 
-	return []Method{}
+	if err := generator.Generate(); err != nil {
+		fatal(err, "Failed to generate code")
+	}
+	summarize(*outputFile)
 }
 
-func extractParams(fieldList *ast.FieldList) []string {
-	if fieldList == nil {
-		return []string{}
-	}
+// Exit codes telling the kinds of failures apart, for scripts driving duck-impl
+const (
+	exitFailure           = 1
+	exitInterfaceNotFound = 3
+	exitInvalidPackage    = 4
+	exitWrite             = 5
+)
 
-	params := make([]string, 0, fieldList.NumFields())
-	for _, field := range fieldList.List {
-		typeStr := formatNode(field.Type)
+// fatal logs err after the message formatted from format and args, and exits
+// with the code of its kind
+func fatal(err error, format string, args ...interface{}) {
+	log.Printf("%s: %v", fmt.Sprintf(format, args...), err)
+	os.Exit(exitCode(err))
+}
 
-		// If there are names, use them
-		if len(field.Names) > 0 {
-			for _, name := range field.Names {
-				params = append(params, fmt.Sprintf("%s %s", name.Name, typeStr))
-			}
-		} else {
-			// For unnamed returns
-			params = append(params, typeStr)
-		}
+// looksLikePath reports whether s, a single line, has a directory or a file
+// extension, like headers/license.txt, rather than being text
+func looksLikePath(s string) bool {
+	if strings.Contains(s, "\n") {
+		return false
 	}
-
-	return params
+	ext := filepath.Ext(s)
+	return strings.ContainsRune(s, '/') || strings.ContainsRune(s, filepath.Separator) ||
+		len(ext) > 1 && !strings.ContainsAny(ext, " \t")
 }
 
-func formatNode(node ast.Expr) string {
-	switch n := node.(type) {
-	case *ast.Ident:
-		return n.Name
-	case *ast.SelectorExpr:
-		return formatNode(n.X) + "." + n.Sel.Name
-	case *ast.StarExpr:
-		return "*" + formatNode(n.X)
-	case *ast.ArrayType:
-		if n.Len == nil {
-			return "[]" + formatNode(n.Elt)
-		}
-		return "[" + formatNode(n.Len) + "]" + formatNode(n.Elt)
-	case *ast.MapType:
-		return "map[" + formatNode(n.Key) + "]" + formatNode(n.Value)
-	case *ast.InterfaceType:
-		return "interface{}"
-	case *ast.FuncType:
-		return "func" + formatFuncParams(n.Params) + formatFuncResults(n.Results)
-	case *ast.BasicLit:
-		return n.Value
-	case *ast.ChanType:
-		switch n.Dir {
-		case ast.SEND:
-			return "chan<- " + formatNode(n.Value)
-		case ast.RECV:
-			return "<-chan " + formatNode(n.Value)
-		default:
-			return "chan " + formatNode(n.Value)
-		}
+// exitCode returns the exit code for err, by its duckimpl error kind
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, duckimpl.ErrInterfaceNotFound), errors.Is(err, duckimpl.ErrNotInterface):
+		return exitInterfaceNotFound
+	case errors.Is(err, duckimpl.ErrInvalidPackage):
+		return exitInvalidPackage
+	case errors.Is(err, duckimpl.ErrWrite):
+		return exitWrite
 	default:
-		return fmt.Sprintf("/* unsupported: %T */", node)
+		return exitFailure
 	}
 }
 
-func formatFuncParams(fields *ast.FieldList) string {
-	if fields == nil {
-		return "()"
-	}
+// cleanName strips the package qualifier from an interface name
+func cleanName(s string) string {
+	return s[strings.LastIndex(s, ".")+1:]
+}
 
-	params := make([]string, 0, fields.NumFields())
-	for _, field := range fields.List {
-		typeStr := formatNode(field.Type)
+// interfaceRef returns how the generated package refers to iface. Unless the
+// interface is local, the import of its package is added to imports.
+func interfaceRef(iface duckimpl.Interface, methods []duckimpl.Method, imports map[string]string) string {
+	ref := cleanName(iface.Name)
+	if !strings.Contains(iface.Name, ".") || iface.PkgPath == "" {
+		return ref
+	}
 
-		if len(field.Names) > 0 {
-			for _, name := range field.Names {
-				params = append(params, fmt.Sprintf("%s %s", name.Name, typeStr))
-			}
-		} else {
-			params = append(params, typeStr)
+	// reuse the name the package is already imported by, if any
+	pkgName := iface.Package
+	for _, method := range methods {
+		if name, ok := method.Imports[iface.PkgPath]; ok {
+			pkgName = name
 		}
 	}
-
-	return "(" + strings.Join(params, ", ") + ")"
+	imports[iface.PkgPath] = pkgName
+	return pkgName + "." + ref
 }
 
-func formatFuncResults(fields *ast.FieldList) string {
-	if fields == nil || fields.NumFields() == 0 {
-		return ""
-	}
-
-	if fields.NumFields() == 1 && len(fields.List[0].Names) == 0 {
-		return " " + formatNode(fields.List[0].Type)
+// splitNames splits a comma-separated list of names, ignoring empty entries
+func splitNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
 	}
+	return names
+}
 
-	params := make([]string, 0, fields.NumFields())
-	for _, field := range fields.List {
-		typeStr := formatNode(field.Type)
+// commandLine returns the invocation of duck-impl, quoting arguments where
+// needed so it can be copied back into a shell. Flags that only decide what
+// is done with the generated code are left out, so they do not change it.
+// The flags set are listed by their long names in sorted order, so that
+// equivalent invocations record the same header, as -check relies on.
+func commandLine() string {
+	output := []string{"check", "debug", "diff", "logLevel", "preview", "trace", "v", "watch"}
+	set := make(map[string]*flag.Flag)
+	flag.Visit(func(f *flag.Flag) {
+		name := f.Name
+		if long, ok := strings.CutPrefix(f.Usage, "Alias for -"); ok {
+			name = long
+		}
+		if !slices.Contains(output, name) {
+			set[name] = f
+		}
+	})
 
-		if len(field.Names) > 0 {
-			for _, name := range field.Names {
-				params = append(params, fmt.Sprintf("%s %s", name.Name, typeStr))
+	args := []string{"duck-impl"}
+	for _, name := range slices.Sorted(maps.Keys(set)) {
+		value := set[name].Value
+		if b, ok := value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			if value.String() == "true" {
+				args = append(args, "-"+name)
+			} else {
+				args = append(args, "-"+name+"="+value.String())
 			}
-		} else {
-			params = append(params, typeStr)
+			continue
 		}
+		args = append(args, "-"+name, shellQuote(value.String()))
 	}
-
-	return " (" + strings.Join(params, ", ") + ")"
-}
-
-// Method signature formatting functions
-func (g *Generator) formatMethodParams(params []string) string {
-	if len(params) == 0 {
-		return "()"
+	for _, arg := range flag.Args() {
+		args = append(args, shellQuote(arg))
 	}
-	return "(" + strings.Join(params, ", ") + ")"
+	return strings.Join(args, " ")
 }
 
-func (g *Generator) formatMethodResults(results []string) string {
-	if len(results) == 0 {
-		return ""
+// shellQuote quotes arg if a shell would not take it as is
+func shellQuote(arg string) string {
+	if arg == "" || strings.ContainsAny(arg, " \t\n\"'$`\\[]*?") {
+		return strconv.Quote(arg)
 	}
-	return " (" + strings.Join(results, ", ") + ")"
+	return arg
 }
 
-const tmpl = `// Code generated by duck-impl; DO NOT EDIT.
-
-package {{.PackageName}}
+// splitTypeParams splits a struct spec like "MyStore[K, V]" into its name and
+// type parameter names. The names are nil when the spec has no brackets.
+func splitTypeParams(spec string) (string, []string) {
+	open := strings.Index(spec, "[")
+	if open == -1 || !strings.HasSuffix(spec, "]") {
+		return spec, nil
+	}
 
-import (
-{{- range .Imports}}
-	"{{.}}"
-{{- end}}
-)
+	names := strings.Split(spec[open+1:len(spec)-1], ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+	}
+	return spec[:open], names
+}
 
-type _{{clean .InterfaceName}}_ struct {
-{{- range .Methods}}
-	{{.MethodName|lowerInitalChar}} func{{formatParams .Parameters}}{{formatResults .Results}}
-{{- end}}
+// splitTypeArgs splits an interface spec like "Cache[string, []byte]" into
+// its name and type arguments, splitting only at top-level commas so that
+// arguments like map[K]V or func(a, b int) stay whole. The arguments are nil
+// when the spec has no brackets.
+func splitTypeArgs(spec string) (string, []string) {
+	open := strings.Index(spec, "[")
+	if open == -1 || !strings.HasSuffix(spec, "]") {
+		return spec, nil
+	}
+
+	var args []string
+	depth, start := 0, open+1
+	inner := spec[:len(spec)-1]
+	for i := start; i < len(inner); i++ {
+		switch inner[i] {
+		case '[', '(', '{':
+			depth++
+		case ']', ')', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(inner[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	args = append(args, strings.TrimSpace(inner[start:]))
+	return spec[:open], args
 }
 
-{{- range .Methods}}
+// jsonMethod is the JSON representation of a Method
+type jsonMethod struct {
+	Name       string   `json:"name"`
+	Parameters []string `json:"parameters"`
+	Results    []string `json:"results"`
+	Variadic   bool     `json:"variadic"`
+	Imports    []string `json:"imports"`
+}
 
-func ({{clean $.InterfaceName | toLower}}_impl _{{clean $.InterfaceName}}_) {{.MethodName}}{{formatParams .Parameters}}{{formatResults .Results}} {
-	{{if hasResults .Results}}return {{end}}{{clean $.InterfaceName | toLower}}_impl.{{.MethodName|lowerInitalChar}}{{callParams .Parameters}}
+// jsonInterface is the document written by -format json
+type jsonInterface struct {
+	Interface string       `json:"interface"`
+	Package   string       `json:"package"`
+	Methods   []jsonMethod `json:"methods"`
 }
-{{- end}}
-
-type {{.StructName}} = _{{clean .InterfaceName}}_
-`
-
-func (g *Generator) Generate() error {
-	// Create template
-	tmpl := template.Must(
-		template.New("codegen").Funcs(template.FuncMap{
-			"clean": func(s string) string {
-				parts := strings.Split(s, ".")
-				if len(parts) > 1 {
-					return parts[len(parts)-1]
-				}
-				return s
-			},
-			"lowerInitalChar": func(s string) string { return strings.ToLower(s[:1]) + s[1:] },
-			"toLower":         strings.ToLower,
-			"formatParams":    g.formatMethodParams,
-			"formatResults":   g.formatMethodResults,
-			"callParams": func(params []string) string {
-				if len(params) == 0 {
-					return "()"
-				}
-
-				paramNames := make([]string, len(params))
-				for i, param := range params {
-					parts := strings.SplitN(param, " ", 2)
-					paramNames[i] = parts[0]
-				}
-
-				return "(" + strings.Join(paramNames, ", ") + ")"
-			},
-			"hasResults": func(results []string) bool {
-				return len(results) > 0
-			},
-		}).Parse(tmpl))
-
-	// Create output file
-	file, err := os.Create(g.OutputFile)
-	if err != nil {
-		return fmt.Errorf("could not create output file: %v", err)
+
+// writeMethodsJSON serializes the parsed methods to w. Methods are sorted by
+// name and imports alphabetically so the output is stable across runs.
+func writeMethodsJSON(w io.Writer, interfaceName, hostPkgName string, methods []duckimpl.Method) error {
+	doc := jsonInterface{
+		Interface: interfaceName,
+		Package:   hostPkgName,
+		Methods:   make([]jsonMethod, 0, len(methods)),
 	}
-	defer file.Close()
 
-	// Execute template
-	err = tmpl.Execute(file, g)
-	if err != nil {
-		return fmt.Errorf("could not execute template: %v", err)
+	for _, method := range methods {
+		imports := make([]string, 0, len(method.Imports))
+		for imp := range method.Imports {
+			imports = append(imports, imp)
+		}
+		sort.Strings(imports)
+
+		doc.Methods = append(doc.Methods, jsonMethod{
+			Name:       method.MethodName,
+			Parameters: method.Parameters(),
+			Results:    method.Results(),
+			Variadic:   method.Variadic,
+			Imports:    imports,
+		})
 	}
+	sort.Slice(doc.Methods, func(i, j int) bool { return doc.Methods[i].Name < doc.Methods[j].Name })
 
-	return nil
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
 }
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// duckImpl is the duck-impl binary built by TestMain
+var duckImpl string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "duck-impl")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	duckImpl = filepath.Join(dir, "duck-impl")
+	if out, err := exec.Command("go", "build", "-o", duckImpl, ".").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "building duck-impl: %v\n%s", err, out)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// run runs duck-impl with args in dir, returning its stdout, its stderr and
+// its exit code
+func run(t *testing.T, dir string, args ...string) (string, string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(duckImpl, args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.String(), stderr.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("running duck-impl: %v", err)
+	}
+	return stdout.String(), stderr.String(), 0
+}
+
+// module writes the files into a new module example.com/m, returning its
+// directory
+func module(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	files["go.mod"] = "module example.com/m\n\ngo 1.22\n"
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// store declares the interface most tests implement
+const store = "package m\n\ntype Store interface {\n\tGet(key string) ([]byte, error)\n\tPut(key string, value []byte) error\n}\n"
+
+func TestEmptyDirNeedsPackage(t *testing.T) {
+	dir := t.TempDir()
+	_, stderr, code := run(t, dir, "-s", "R", "-i", "io.Reader", "-o", "gen.go")
+	if code != exitInvalidPackage {
+		t.Fatalf("exit code = %d, want %d; stderr:\n%s", code, exitInvalidPackage, stderr)
+	}
+	if !strings.Contains(stderr, "-package") {
+		t.Errorf("stderr does not suggest -package:\n%s", stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gen.go")); !os.IsNotExist(err) {
+		t.Errorf("gen.go was written: %v", err)
+	}
+
+	_, stderr, code = run(t, dir, "-s", "R", "-i", "io.Reader", "-o", "gen.go", "-package", "scaffold")
+	if code != 0 {
+		t.Fatalf("exit code = %d with -package; stderr:\n%s", code, stderr)
+	}
+	src, err := os.ReadFile(filepath.Join(dir, "gen.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(src, []byte("\npackage scaffold\n")) {
+		t.Errorf("gen.go does not declare package scaffold:\n%s", src)
+	}
+}
+
+func TestDebugPreviewStdout(t *testing.T) {
+	dir := module(t, map[string]string{
+		"store.go": "package m\n\nimport \"context\"\n\ntype Store interface {\n\tGet(ctx context.Context, key string) ([]byte, error)\n}\n",
+	})
+
+	stdout, stderr, code := run(t, dir, "-debug", "-preview", "-s", "S", "-i", "Store", "-o", "gen.go")
+	if code != 0 {
+		t.Fatalf("exit code = %d; stderr:\n%s", code, stderr)
+	}
+	if stderr == "" {
+		t.Error("-debug logged nothing to stderr")
+	}
+
+	// stdout holds the generated code only
+	if !strings.HasPrefix(stdout, "// Code generated by duck-impl; DO NOT EDIT.") {
+		t.Errorf("stdout does not start with the generated code:\n%s", stdout)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "gen.go", stdout, parser.AllErrors); err != nil {
+		t.Errorf("stdout is not Go source: %v\n%s", err, stdout)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gen.go")); !os.IsNotExist(err) {
+		t.Errorf("-preview wrote gen.go: %v", err)
+	}
+}
+
+func TestThreadSafeNamedStruct(t *testing.T) {
+	dir := module(t, map[string]string{"store.go": store})
+
+	stdout, stderr, code := run(t, dir, "-threadSafe", "-preview", "-s", "S", "-i", "Store", "-o", "gen.go")
+	if code != 0 {
+		t.Fatalf("exit code = %d; stderr:\n%s", code, stderr)
+	}
+	// an alias would let value copies of the struct copy its mutex
+	for _, want := range []string{"type S struct {", "func (store_impl *S) Get("} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("generated code does not contain %q:\n%s", want, stdout)
+		}
+	}
+	if strings.Contains(stdout, "type S = ") {
+		t.Errorf("-threadSafe generated an alias:\n%s", stdout)
+	}
+}
+
+func TestDiffChangedMethod(t *testing.T) {
+	dir := module(t, map[string]string{"store.go": store})
+	if _, stderr, code := run(t, dir, "-s", "S", "-i", "Store", "-o", "gen.go"); code != 0 {
+		t.Fatalf("exit code = %d; stderr:\n%s", code, stderr)
+	}
+	gen := filepath.Join(dir, "gen.go")
+	src, err := os.ReadFile(gen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := bytes.Replace(src, []byte("return store_impl.put(key, value)"), []byte("return nil"), 1)
+	if err := os.WriteFile(gen, edited, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code := run(t, dir, "-diff", "-s", "S", "-i", "Store", "-o", "gen.go")
+	if code != 0 {
+		t.Fatalf("exit code = %d; stderr:\n%s", code, stderr)
+	}
+	want := `--- gen.go
++++ gen.go (generated)
+@@ -13,7 +13,7 @@
+ }
+ 
+ func (store_impl _Store_) Put(key string, value []byte) error {
+-	return nil
++	return store_impl.put(key, value)
+ }
+ 
+ type S = _Store_
+`
+	if stdout != want {
+		t.Errorf("diff =\n%s\nwant\n%s", stdout, want)
+	}
+	if got, err := os.ReadFile(gen); err != nil || !bytes.Equal(got, edited) {
+		t.Errorf("-diff rewrote gen.go: %v\n%s", err, got)
+	}
+}
+
+func TestOverwrite(t *testing.T) {
+	const handWritten = "package m\n\n// S is written by hand\ntype S struct{}\n"
+	tests := []struct {
+		name     string
+		existing string // "" for no file
+		force    bool
+		code     int
+	}{
+		{"new file", "", false, 0},
+		{"generated file", "// Code generated by duck-impl; DO NOT EDIT.\n\npackage m\n", false, 0},
+		{"hand-written file", handWritten, false, exitWrite},
+		{"hand-written file with -force", handWritten, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"store.go": store}
+			if tt.existing != "" {
+				files["gen.go"] = tt.existing
+			}
+			dir := module(t, files)
+
+			args := []string{"-s", "S", "-i", "Store", "-o", "gen.go"}
+			if tt.force {
+				args = append(args, "-force")
+			}
+			_, stderr, code := run(t, dir, args...)
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d; stderr:\n%s", code, tt.code, stderr)
+			}
+
+			src, err := os.ReadFile(filepath.Join(dir, "gen.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.code != 0 {
+				if string(src) != tt.existing {
+					t.Errorf("gen.go was overwritten:\n%s", src)
+				}
+				if !strings.Contains(stderr, "gen.go") {
+					t.Errorf("stderr does not name gen.go:\n%s", stderr)
+				}
+				return
+			}
+			if !strings.Contains(string(src), "func (store_impl _Store_) Get(") {
+				t.Errorf("gen.go was not generated:\n%s", src)
+			}
+		})
+	}
+}
+
+func TestTraceFallback(t *testing.T) {
+	// a type error in the package makes go/packages fail, so the AST is searched
+	dir := module(t, map[string]string{
+		"store.go":  store,
+		"broken.go": "package m\n\nvar _ = undefined\n",
+	})
+	_, stderr, code := run(t, dir, "-trace", "trace.jsonl", "-s", "S", "-i", "Store", "-o", "gen.go")
+	if code != 0 {
+		t.Fatalf("exit code = %d; stderr:\n%s", code, stderr)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "trace.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var steps []string
+	var records []map[string]any
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("trace line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+		steps = append(steps, fmt.Sprint(record["step"]))
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the steps appear in this order, with others, like scan, in between
+	want := []string{"lookup", "load", "load errors", "fallback", "ast", "found"}
+	next := 0
+	for i, step := range steps {
+		if next == len(want) || step != want[next] {
+			continue
+		}
+		switch step {
+		case "fallback":
+			if reason := fmt.Sprint(records[i]["reason"]); !strings.Contains(reason, "undefined") {
+				t.Errorf("fallback reason = %q, want the type error", reason)
+			}
+		case "found":
+			if via := records[i]["via"]; via != "ast" {
+				t.Errorf("found via %v, want ast", via)
+			}
+		}
+		next++
+	}
+	if next != len(want) {
+		t.Errorf("trace steps = %q, want %q in order", steps, want)
+	}
+}
+
+func TestInstantiateInterface(t *testing.T) {
+	dir := module(t, map[string]string{
+		"box.go":   "package m\n\ntype Box[T any] interface {\n\tGet() T\n\tSet(v T)\n}\n",
+		"check.go": "package m\n\nvar _ Box[int] = IntBox{}\n",
+	})
+	_, stderr, code := run(t, dir, "-s", "IntBox", "-i", "Box[int]", "-o", "gen.go")
+	if code != 0 {
+		t.Fatalf("exit code = %d; stderr:\n%s", code, stderr)
+	}
+	src, err := os.ReadFile(filepath.Join(dir, "gen.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"func (box_impl _Box_) Get() int {", "func (box_impl _Box_) Set(v int) {", "type IntBox = _Box_\n"} {
+		if !bytes.Contains(src, []byte(want)) {
+			t.Errorf("gen.go does not contain %q:\n%s", want, src)
+		}
+	}
+
+	cmd := exec.Command("go", "vet", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("IntBox does not implement Box[int]: %v\n%s", err, out)
+	}
+}
+
+func TestShortFlags(t *testing.T) {
+	dir := module(t, map[string]string{"store.go": store})
+	long, stderr, code := run(t, dir, "-preview", "-struct", "S", "-interface", "Store", "-outputFile", "gen.go")
+	if code != 0 {
+		t.Fatalf("exit code = %d with the long flags; stderr:\n%s", code, stderr)
+	}
+	short, stderr, code := run(t, dir, "-preview", "-s", "S", "-i", "Store", "-o", "gen.go")
+	if code != 0 {
+		t.Fatalf("exit code = %d with the short flags; stderr:\n%s", code, stderr)
+	}
+	if short != long {
+		t.Errorf("the short flags generate\n%s\nthe long ones\n%s", short, long)
+	}
+
+	_, usage, _ := run(t, dir, "-h")
+	for _, alias := range []string{"-o string\n    \tAlias for -outputFile", "-i string\n    \tAlias for -interface", "-s string\n    \tAlias for -struct"} {
+		if !strings.Contains(usage, alias) {
+			t.Errorf("-h does not document %q:\n%s", alias, usage)
+		}
+	}
+}
+
+func TestVerboseSummary(t *testing.T) {
+	dir := module(t, map[string]string{"store.go": store})
+	stdout, stderr, code := run(t, dir, "-v", "-s", "S", "-i", "Store", "-o", "gen.go")
+	if code != 0 {
+		t.Fatalf("exit code = %d; stderr:\n%s", code, stderr)
+	}
+	if stdout != "" {
+		t.Errorf("-v printed to stdout:\n%s", stdout)
+	}
+	for _, want := range []string{"S implementing Store", "example.com/m", "via types", "2 methods", "gen.go"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("summary does not mention %q:\n%s", want, stderr)
+		}
+	}
+}
+
+func TestAppendMergesImports(t *testing.T) {
+	const extra = "package m\n\nimport \"context\"\n\nvar background = context.Background()\n"
+	dir := module(t, map[string]string{
+		"store.go": "package m\n\nimport (\n\t\"context\"\n\t\"io\"\n)\n\ntype Store interface {\n\tGet(ctx context.Context, r io.Reader) error\n}\n",
+		"extra.go": extra,
+	})
+	_, stderr, code := run(t, dir, "-append", "-s", "S", "-i", "Store", "-o", "extra.go")
+	if code != 0 {
+		t.Fatalf("exit code = %d; stderr:\n%s", code, stderr)
+	}
+
+	src, err := os.ReadFile(filepath.Join(dir, "extra.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "extra.go", src, parser.ImportsOnly)
+	if err != nil {
+		t.Fatalf("extra.go does not parse: %v\n%s", err, src)
+	}
+	var imports []string
+	for _, spec := range file.Imports {
+		imports = append(imports, spec.Path.Value)
+	}
+	if want := []string{`"context"`, `"io"`}; !slices.Equal(imports, want) {
+		t.Errorf("imports = %v, want %v", imports, want)
+	}
+	if bytes.Count(src, []byte("package m\n")) != 1 || !bytes.Contains(src, []byte("var background = context.Background()\n")) {
+		t.Errorf("extra.go lost its own code or got a second package clause:\n%s", src)
+	}
+
+	cmd := exec.Command("go", "vet", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("the appended code does not compile: %v\n%s\n%s", err, out, src)
+	}
+}
+
+func TestAppendOtherPackage(t *testing.T) {
+	const other = "package other\n"
+	dir := module(t, map[string]string{"store.go": store, "other.go": other})
+	_, stderr, code := run(t, dir, "-append", "-s", "S", "-i", "Store", "-o", "other.go")
+	if code != exitInvalidPackage {
+		t.Fatalf("exit code = %d, want %d; stderr:\n%s", code, exitInvalidPackage, stderr)
+	}
+	if src, err := os.ReadFile(filepath.Join(dir, "other.go")); err != nil || string(src) != other {
+		t.Errorf("other.go was changed: %v\n%s", err, src)
+	}
+}
+
+func TestWatchRegenerates(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the watcher to regenerate")
+	}
+	dir := module(t, map[string]string{"store.go": store})
+	cmd := exec.Command(duckImpl, "-watch", "-s", "S", "-i", "Store", "-o", "gen.go")
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	// waitFor polls gen.go until it contains want
+	gen := filepath.Join(dir, "gen.go")
+	waitFor := func(want string) {
+		t.Helper()
+		for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			if src, err := os.ReadFile(gen); err == nil && strings.Contains(string(src), want) {
+				return
+			}
+		}
+		src, _ := os.ReadFile(gen)
+		t.Fatalf("gen.go does not contain %q:\n%s\nstderr:\n%s", want, src, stderr.String())
+	}
+	waitFor("func (store_impl _Store_) Put(")
+
+	changed := strings.Replace(store, "\tPut(key string, value []byte) error\n", "\tPut(key string, value []byte) error\n\tDelete(key string) error\n", 1)
+	if err := os.WriteFile(filepath.Join(dir, "store.go"), []byte(changed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("func (store_impl _Store_) Delete(")
+}
+
+func TestHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		code   int
+		want   string // in gen.go, or in stderr on failure
+	}{
+		{"file", "license.txt", 0, "// Copyright Acme\n"},
+		{"comment", "// Licensed to Acme", 0, "// Licensed to Acme\n"},
+		{"word", "Acme", 0, "// Acme\n"},
+		{"missing file", "licence.txt", exitFailure, "licence.txt"},
+		{"missing path", "headers/license", exitFailure, "headers/license"},
+		{"directory", "headers", exitFailure, "headers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := module(t, map[string]string{"store.go": store, "license.txt": "// Copyright Acme\n"})
+			if err := os.Mkdir(filepath.Join(dir, "headers"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			_, stderr, code := run(t, dir, "-header", tt.header, "-s", "S", "-i", "Store", "-o", "gen.go")
+			if code != tt.code {
+				t.Fatalf("exit code = %d, want %d; stderr:\n%s", code, tt.code, stderr)
+			}
+			if code != 0 {
+				if !strings.Contains(stderr, tt.want) {
+					t.Errorf("stderr does not name %s:\n%s", tt.want, stderr)
+				}
+				if _, err := os.Stat(filepath.Join(dir, "gen.go")); !os.IsNotExist(err) {
+					t.Errorf("gen.go was written: %v", err)
+				}
+				return
+			}
+			src, err := os.ReadFile(filepath.Join(dir, "gen.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(src), tt.want) {
+				t.Errorf("gen.go does not contain the header %q:\n%s", tt.want, src)
+			}
+		})
+	}
+}
@@ -0,0 +1,691 @@
+// Package duckimpl parses Go interfaces and generates structs of function
+// fields implementing them, so interfaces can be satisfied with closures.
+package duckimpl
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Interface is a parsed interface
+type Interface struct {
+	Name       string  // name as requested, possibly qualified as path/to/pkg.Interface
+	Package    string  // name of the package declaring the interface
+	PkgPath    string  // import path of the package declaring the interface, if known
+	TypeParams []Param // type parameters of a generic interface, with their constraints as Type
+	Methods    []Method
+
+	TypeParamImports map[string]string // import path -> name qualifying the constraints of TypeParams
+	Via              string            // how the interface was resolved: "types" with go/types, or "ast" by the AST fallback
+}
+
+// Param is a single parameter or result of a method
+type Param struct {
+	Name string // empty for unnamed results
+	Type string // type as written in generated code, "...T" for variadic parameters
+}
+
+// String returns the parameter as it appears in a signature, "name type"
+func (p Param) String() string {
+	if p.Name == "" {
+		return p.Type
+	}
+	return p.Name + " " + p.Type
+}
+
+// RenameTypeParams returns a copy of the interface whose type parameters are
+// renamed positionally to names, throughout constraints and method signatures
+func (i Interface) RenameTypeParams(names []string) (Interface, error) {
+	if len(names) != len(i.TypeParams) {
+		return Interface{}, fmt.Errorf("%s has %d type parameters, got %d names", i.Name, len(i.TypeParams), len(names))
+	}
+
+	renames := make(map[string]string, len(names))
+	for j, name := range names {
+		if !token.IsIdentifier(name) || token.IsKeyword(name) {
+			return Interface{}, fmt.Errorf("type parameter name %q is not a valid Go identifier", name)
+		}
+		renames[i.TypeParams[j].Name] = name
+	}
+
+	renameAll := func(params []Param) []Param {
+		renamed := make([]Param, len(params))
+		for j, param := range params {
+			renamed[j] = Param{Name: param.Name, Type: renameIdents(param.Type, renames)}
+		}
+		return renamed
+	}
+
+	renamed := i
+	renamed.TypeParams = make([]Param, len(i.TypeParams))
+	for j, tparam := range i.TypeParams {
+		renamed.TypeParams[j] = Param{Name: names[j], Type: renameIdents(tparam.Type, renames)}
+	}
+	renamed.Methods = make([]Method, len(i.Methods))
+	for j, method := range i.Methods {
+		method.Params = renameAll(method.Params)
+		method.Returns = renameAll(method.Returns)
+		renamed.Methods[j] = method
+	}
+	return renamed, nil
+}
+
+// qualifiedTypePattern matches a type qualified by a full import path, like
+// example.com/app/store.Item or net/http.Header
+var qualifiedTypePattern = regexp.MustCompile(`([\w.~-]+(?:/[\w.~-]+)+)\.([A-Za-z_]\w*)`)
+
+// Instantiate substitutes the type arguments args, like "int" or
+// "time.Duration", for the type parameters of a generic interface, returning
+// a non-generic interface. An argument may be qualified by a package the
+// interface imports, by a standard library package, like time, or by a full
+// import path, like example.com/app/store.Item; the go command resolves the
+// packages the interface does not import relative to the package in dir.
+// Whether the arguments satisfy the constraints is left to the compiler.
+func (i Interface) Instantiate(dir string, args []string) (Interface, error) {
+	if len(args) != len(i.TypeParams) {
+		return Interface{}, fmt.Errorf("%s has %d type parameters, got %d type arguments", i.Name, len(i.TypeParams), len(args))
+	}
+
+	// packages the arguments may refer to, by name
+	known := make(map[string]string)
+	for path, name := range i.TypeParamImports {
+		known[name] = path
+	}
+	for _, method := range i.Methods {
+		for path, name := range method.Imports {
+			known[name] = path
+		}
+	}
+
+	// refer to the packages qualifying an argument by their full path by name
+	args = slices.Clone(args)
+	var paths []string
+	for _, arg := range args {
+		for _, match := range qualifiedTypePattern.FindAllStringSubmatch(arg, -1) {
+			paths = append(paths, match[1])
+		}
+	}
+	if len(paths) > 0 {
+		names, err := packageNames(dir, paths)
+		if err != nil {
+			return Interface{}, err
+		}
+		for _, path := range paths {
+			name, ok := names[path]
+			if !ok {
+				return Interface{}, fmt.Errorf("type argument refers to package %s, which cannot be found", path)
+			}
+			if other, ok := known[name]; ok && other != path {
+				return Interface{}, fmt.Errorf("type argument refers to package %s, whose name %s is taken by %s", path, name, other)
+			}
+			known[name] = path
+		}
+		for j, arg := range args {
+			args[j] = qualifiedTypePattern.ReplaceAllStringFunc(arg, func(s string) string {
+				match := qualifiedTypePattern.FindStringSubmatch(s)
+				return names[match[1]] + "." + match[2]
+			})
+		}
+	}
+
+	exprs := make([]ast.Expr, len(args))
+	var unknown []string
+	for j, arg := range args {
+		expr, err := parser.ParseExpr(arg)
+		if err != nil {
+			return Interface{}, fmt.Errorf("type argument %q is not a valid type: %v", arg, err)
+		}
+		exprs[j] = expr
+		forEachQualifier(expr, func(name string) {
+			if _, ok := known[name]; !ok && !slices.Contains(unknown, name) {
+				unknown = append(unknown, name)
+			}
+		})
+	}
+
+	// other qualifiers may name standard library packages, like time
+	if len(unknown) > 0 {
+		names, err := packageNames(dir, unknown)
+		if err != nil {
+			return Interface{}, err
+		}
+		for _, name := range unknown {
+			if names[name] != name {
+				return Interface{}, fmt.Errorf("type argument refers to package %s, which %s does not import; qualify it by its import path", name, i.Name)
+			}
+			known[name] = name
+		}
+	}
+
+	renames := make(map[string]string, len(args))
+	argImports := make(map[string]string)
+	for j, expr := range exprs {
+		forEachQualifier(expr, func(name string) {
+			argImports[known[name]] = name
+		})
+		renames[i.TypeParams[j].Name] = args[j]
+	}
+
+	instantiateAll := func(params []Param) ([]Param, bool) {
+		instantiated := make([]Param, len(params))
+		changed := false
+		for j, param := range params {
+			instantiated[j] = Param{Name: param.Name, Type: renameIdents(param.Type, renames)}
+			changed = changed || instantiated[j].Type != param.Type
+		}
+		return instantiated, changed
+	}
+
+	instance := i
+	instance.TypeParams = nil
+	instance.TypeParamImports = nil
+	instance.Methods = make([]Method, len(i.Methods))
+	for j, method := range i.Methods {
+		var paramsChanged, returnsChanged bool
+		method.Params, paramsChanged = instantiateAll(method.Params)
+		method.Returns, returnsChanged = instantiateAll(method.Returns)
+		method.Embedded = renameIdents(method.Embedded, renames)
+		if paramsChanged || returnsChanged {
+			imports := make(map[string]string, len(method.Imports)+len(argImports))
+			for path, name := range method.Imports {
+				imports[path] = name
+			}
+			for path, name := range argImports {
+				imports[path] = name
+			}
+			method.Imports = imports
+		}
+		instance.Methods[j] = method
+	}
+	return instance, nil
+}
+
+// renameIdents replaces every identifier token of src found in renames.
+// Selectors like pkg.K are left alone since only their package part is a
+// standalone identifier.
+func renameIdents(src string, renames map[string]string) string {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+
+	var s scanner.Scanner
+	s.Init(file, []byte(src), nil, 0)
+
+	var buf strings.Builder
+	last := 0
+	prev := token.ILLEGAL
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		if newName, ok := renames[lit]; ok && tok == token.IDENT && prev != token.PERIOD {
+			offset := file.Offset(pos)
+			buf.WriteString(src[last:offset])
+			buf.WriteString(newName)
+			last = offset + len(lit)
+		}
+		prev = tok
+	}
+	buf.WriteString(src[last:])
+
+	return buf.String()
+}
+
+// Method describes a single method of the parsed interface
+type Method struct {
+	MethodName string
+	Params     []Param
+	Returns    []Param
+	Variadic   bool              // whether the last parameter is variadic
+	Doc        string            // doc comment of the interface method, without comment markers
+	Imports    map[string]string // import path -> name qualifying its types in paramType and resType
+
+	Embedded        string            // embedded interface the method was promoted from, empty for explicit methods
+	EmbeddedImports map[string]string // imports needed to reference Embedded, like Imports
+}
+
+// String renders the method as declared in an interface, like
+// Read(p []byte) (n int, err error)
+func (m Method) String() string {
+	return m.MethodName + formatMethodParams(m.Parameters()) + formatMethodResults(m.Results())
+}
+
+// Parameters returns the parameters joined as "paramName paramType"
+func (m Method) Parameters() []string {
+	return joinParams(m.Params)
+}
+
+// Results returns the results joined as "resName resType", or just
+// "resType" for unnamed results
+func (m Method) Results() []string {
+	return joinParams(m.Returns)
+}
+
+func joinParams(params []Param) []string {
+	joined := make([]string, len(params))
+	for i, param := range params {
+		joined[i] = param.String()
+	}
+	return joined
+}
+
+// AliasImports renames the imports whose name is in taken, like the
+// identifiers declared by the generated package, to unique aliases such as
+// httpx for net/http, and requalifies every type string referring to them
+func (g *Generator) AliasImports(taken map[string]bool) {
+	used := make(map[string]bool, len(g.Imports))
+	for _, imp := range g.Imports {
+		used[imp.Name] = true
+	}
+
+	renamed := make(map[string]string)
+	for i, imp := range g.Imports {
+		if !taken[imp.Name] {
+			continue
+		}
+		alias := imp.Name + "x"
+		for n := 2; taken[alias] || used[alias]; n++ {
+			alias = fmt.Sprintf("%sx%d", imp.Name, n)
+		}
+		infoLog("Importing %s as %s, since %s is declared by the package\n", imp.Path, alias, imp.Name)
+		used[alias] = true
+		renamed[imp.Name] = alias
+		g.Imports[i].Name = alias
+	}
+	if len(renamed) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(renamed))
+	for name := range renamed {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	qualified := regexp.MustCompile(`\b(` + strings.Join(names, "|") + `)\.`)
+	requalify := func(s string) string {
+		return qualified.ReplaceAllStringFunc(s, func(match string) string {
+			return renamed[strings.TrimSuffix(match, ".")] + "."
+		})
+	}
+	requalifyImports := func(imports map[string]string) map[string]string {
+		if imports == nil {
+			return nil
+		}
+		out := make(map[string]string, len(imports))
+		for path, name := range imports {
+			if alias, ok := renamed[name]; ok {
+				name = alias
+			}
+			out[path] = name
+		}
+		return out
+	}
+	requalifyParams := func(params []Param) []Param {
+		out := make([]Param, len(params))
+		for i, param := range params {
+			out[i] = Param{Name: param.Name, Type: requalify(param.Type)}
+		}
+		return out
+	}
+
+	for i, method := range g.Methods {
+		g.Methods[i].Params = requalifyParams(method.Params)
+		g.Methods[i].Returns = requalifyParams(method.Returns)
+		g.Methods[i].Imports = requalifyImports(method.Imports)
+		g.Methods[i].Embedded = requalify(method.Embedded)
+		g.Methods[i].EmbeddedImports = requalifyImports(method.EmbeddedImports)
+	}
+	g.TypeParams = requalifyParams(g.TypeParams)
+	for i := range g.Delegates {
+		g.Delegates[i] = requalify(g.Delegates[i])
+	}
+	for i := range g.Implements {
+		g.Implements[i] = requalify(g.Implements[i])
+	}
+	g.InterfaceType = requalify(g.InterfaceType)
+}
+
+// forEachQualifier calls fn with the package name qualifying each type in expr
+func forEachQualifier(expr ast.Expr, fn func(name string)) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if pkg, ok := sel.X.(*ast.Ident); ok {
+			fn(pkg.Name)
+		}
+		return false
+	})
+}
+
+// packageNames returns the names of the packages at the import paths that
+// the go command finds relative to dir, by import path
+func packageNames(dir string, paths []string) (map[string]string, error) {
+	cmd := exec.Command("go", append([]string{"list", "-e", "-f", "{{.ImportPath}} {{.Name}}"}, paths...)...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errorf(ErrInvalidPackage, "failed to resolve packages %s: %v", strings.Join(paths, ", "), err)
+	}
+	names := make(map[string]string, len(paths))
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if path, name, ok := strings.Cut(line, " "); ok && name != "" {
+			names[path] = name
+		}
+	}
+	return names, nil
+}
+
+// PackageImportPath returns the import path of the package in dir, which
+// need not hold any Go files yet.
+func PackageImportPath(dir string) (string, error) {
+	// -e reports the import path of a directory without Go files yet
+	cmd := exec.Command("go", "list", "-e", "-f", "{{.ImportPath}}", ".")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", errorf(ErrInvalidPackage, "failed to determine the import path of %s: %v", dir, err)
+	}
+	importPath := strings.TrimSpace(string(output))
+	// outside a module, go list makes up a path no file can import
+	if importPath == "" || strings.HasPrefix(importPath, "_") || importPath == "command-line-arguments" {
+		return "", errorf(ErrInvalidPackage, "%s is not in a module", dir)
+	}
+	return importPath, nil
+}
+
+// CheckInternalImports logs a warning for every import of an internal
+// package, like example.com/app/internal/foo, that the package in dir is not
+// allowed to import since it is outside example.com/app. Such a file does not
+// compile, but it is still generated so the user can decide how to fix it.
+func (g *Generator) CheckInternalImports(dir string) {
+	var internal []Import
+	for _, imp := range g.Imports {
+		if _, ok := internalRoot(imp.Path); ok {
+			internal = append(internal, imp)
+		}
+	}
+	if len(internal) == 0 {
+		return
+	}
+
+	importer, err := PackageImportPath(dir)
+	if err != nil {
+		debugLog("Cannot check the internal imports, the import path of %s is unknown: %v\n", dir, err)
+		return
+	}
+
+	for _, imp := range internal {
+		root, _ := internalRoot(imp.Path)
+		if root != "" && (importer == root || strings.HasPrefix(importer, root+"/")) {
+			continue
+		}
+		infoLog("Warning: %s cannot import the internal package %s, only packages under %s can, so the generated file will not compile\n", importer, imp.Path, root)
+	}
+}
+
+// internalRoot returns the path under which the package at importPath may be
+// imported, the path before its last internal element, and whether it is an
+// internal package at all. The root of a path starting with internal is "".
+func internalRoot(importPath string) (string, bool) {
+	switch i := strings.LastIndex("/"+importPath+"/", "/internal/"); {
+	case i < 0:
+		return "", false
+	case i == 0:
+		return "", true
+	default:
+		return importPath[:i-1], true
+	}
+}
+
+// Import is a single line of the generated import block
+type Import struct {
+	Name string // name the generated code refers to the package by
+	Path string
+}
+
+// Alias returns the explicit import name to emit, or "" when the package
+// name matches the last element of its path
+func (imp Import) Alias() string {
+	if imp.Name == path.Base(imp.Path) {
+		return ""
+	}
+	return imp.Name
+}
+
+// Generator renders a struct of function fields implementing an interface
+type Generator struct {
+	StructName      string
+	InterfaceName   string
+	OutputFile      string
+	Force           bool // overwrite OutputFile even if it is not a generated file
+	PackageName     string
+	Header          string  // comment block before the package clause; a DO NOT EDIT marker is added when missing
+	BuildTags       string  // build constraint expression, like "integration && !race", emitted before the header
+	Template        string  // text/template source replacing the built-in template when set
+	FieldSuffix     string  // suffix of the function field names, appended to the lowercased method names, like readFn
+	LocalPrefix     string  // comma separated import path prefixes grouped after other imports, like goimports -local
+	Gofmt           bool    // format the generated source with gofmt, failing if it does not parse
+	FutureProof     bool    // embed InterfaceType in the struct so it keeps satisfying the interface as methods are added
+	Fallback        bool    // embed InterfaceType in the struct and forward the methods whose function field is nil to it
+	ZeroStub        bool    // return zero values from methods whose function field is nil
+	NamedStruct     bool    // declare StructName as the struct itself rather than an alias of _Interface_
+	Spy             bool    // record the arguments of every call in a <Method>Calls field; methods get pointer receivers
+	ThreadSafe      bool    // serialize calls with an unexported sync.Mutex, which must be imported; methods get pointer receivers
+	PtrReceiver     bool    // give generated methods pointer receivers so they can mutate fields added to the struct
+	Decorator       bool    // generate StructName+"Logger" logging calls to a wrapped implementation instead of the function-field struct; log must be imported
+	Timing          bool    // generate StructName+"Timing" reporting call durations of a wrapped implementation instead of the function-field struct; time must be imported
+	WrapErrors      bool    // under Decorator or Timing, wrap a non-nil error returned last with the struct and method names
+	FuncAdapter     bool    // generate a StructName+"Func" func type implementing the single method of the interface by calling itself
+	Unimplemented   bool    // generate "Unimplemented"+StructName, whose methods panic, instead of the function-field struct
+	Options         bool    // generate an Option type, a With<Method> option per method and a New constructor applying them
+	InterfaceType   string  // interface type as referenced from the generated package, like io.Reader
+	Receiver        string  // receiver identifier for generated methods; derived from InterfaceName when empty
+	TypeParams      []Param // type parameters of a generic interface, repeated on the generated struct
+	Methods         []Method
+	Delegates       []string // embedded interfaces kept as struct fields under -embedded=delegate
+	Verify          bool     // generate a Verify(t testing.TB) method reporting unset function fields, and under Spy methods never called
+	GroupByEmbedded bool     // group generated methods under a comment naming the embedded interface they come from
+	Implements      []string // interfaces, as referenced from the generated package, asserted to be satisfied by StructName
+	Imports         []Import // deduplicated list of imports
+}
+
+// MergeInterfaces unions the method sets of ifaces into a single interface
+// called name. Methods declared by several interfaces must have identical
+// signatures. Generic interfaces cannot be merged.
+func MergeInterfaces(name string, ifaces ...Interface) (Interface, error) {
+	merged := Interface{Name: name}
+	declaredBy := make(map[string]string) // method name -> interface
+	importedAs := make(map[string]string) // import name -> path
+	index := make(map[string]int)         // method name -> index in merged.Methods
+	for _, iface := range ifaces {
+		if len(iface.TypeParams) > 0 {
+			return Interface{}, fmt.Errorf("cannot merge generic interface %s", iface.Name)
+		}
+
+		for _, method := range iface.Methods {
+			for importPath, pkgName := range method.Imports {
+				if other, ok := importedAs[pkgName]; ok && other != importPath {
+					return Interface{}, fmt.Errorf("%s refers to %s as %s, which is already used for %s", iface.Name, importPath, pkgName, other)
+				}
+				importedAs[pkgName] = importPath
+			}
+
+			i, ok := index[method.MethodName]
+			if !ok {
+				index[method.MethodName] = len(merged.Methods)
+				declaredBy[method.MethodName] = iface.Name
+				merged.Methods = append(merged.Methods, method)
+				continue
+			}
+
+			if first := merged.Methods[i]; signature(first) != signature(method) {
+				return Interface{}, fmt.Errorf("method %s has conflicting signatures %s in %s and %s in %s",
+					method.MethodName, signature(first), declaredBy[method.MethodName], signature(method), iface.Name)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// Modes accepted by ApplyEmbeddedMode
+const (
+	EmbeddedFlatten  = "flatten"  // generate a function field for every method, including embedded ones
+	EmbeddedSkip     = "skip"     // omit methods promoted from embedded interfaces
+	EmbeddedDelegate = "delegate" // embed each embedded interface as a field and forward to it
+)
+
+// Kinds of errors returned by the parsing and generation functions, which
+// callers can tell apart with errors.Is
+var (
+	ErrInterfaceNotFound = errors.New("interface not found")
+	ErrNotInterface      = errors.New("not an interface type")
+	ErrInvalidPackage    = errors.New("invalid package")
+	ErrWrite             = errors.New("could not write output file")
+)
+
+// kindError is an error of one of the kinds above with its own message
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+// errorf formats an error of the given kind, like fmt.Errorf
+func errorf(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+// DebugLog receives debug messages about how interfaces are resolved. It
+// discards them by default.
+var DebugLog = func(format string, args ...interface{}) {}
+
+// InfoLog receives the fewer messages worth knowing without the details of
+// DebugLog, like warnings, fallbacks and which interface was picked. It
+// discards them by default.
+var InfoLog = func(format string, args ...interface{}) {}
+
+func debugLog(format string, args ...interface{}) {
+	DebugLog(format, args...)
+}
+
+func infoLog(format string, args ...interface{}) {
+	InfoLog(format, args...)
+}
+
+// Trace receives a structured record of each step taken to resolve an
+// interface, like package load attempts, the AST fallback engaging and the
+// files scanned. It discards them by default.
+var Trace = func(step string, details map[string]interface{}) {}
+
+func trace(step string, details map[string]interface{}) {
+	Trace(step, details)
+}
+
+// ApplyEmbeddedMode filters methods according to the embedded mode. Under
+// skip and delegate, methods promoted from embedded interfaces are dropped;
+// delegate additionally returns the embedded interfaces to add as struct
+// fields along with the imports they require.
+func ApplyEmbeddedMode(methods []Method, mode string) ([]Method, []string, map[string]string) {
+	if mode == EmbeddedFlatten {
+		return methods, nil, nil
+	}
+
+	var delegates []string
+	imports := make(map[string]string)
+	seen := make(map[string]bool)
+	explicit := make([]Method, 0, len(methods))
+	for _, method := range methods {
+		if method.Embedded == "" {
+			explicit = append(explicit, method)
+			continue
+		}
+
+		if mode == EmbeddedDelegate && !seen[method.Embedded] {
+			seen[method.Embedded] = true
+			delegates = append(delegates, method.Embedded)
+			for path, name := range method.EmbeddedImports {
+				imports[path] = name
+			}
+		}
+	}
+
+	return explicit, delegates, imports
+}
+
+// FilterMethods keeps the methods named in only, or all of them when only is
+// empty, and drops those named in exclude. Naming a method that is not among
+// methods is an error, to catch typos.
+func FilterMethods(methods []Method, only, exclude []string) ([]Method, error) {
+	known := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		known[method.MethodName] = true
+	}
+	names := func(list []string) (map[string]bool, error) {
+		set := make(map[string]bool, len(list))
+		for _, name := range list {
+			if !known[name] {
+				return nil, fmt.Errorf("interface has no method %s", name)
+			}
+			set[name] = true
+		}
+		return set, nil
+	}
+
+	included, err := names(only)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := names(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]Method, 0, len(methods))
+	for _, method := range methods {
+		if (len(only) == 0 || included[method.MethodName]) && !excluded[method.MethodName] {
+			filtered = append(filtered, method)
+		}
+	}
+	return filtered, nil
+}
+
+// SortMethods sorts methods by name, so generated code does not depend on the
+// order in which methods were resolved
+func SortMethods(methods []Method) {
+	sort.SliceStable(methods, func(i, j int) bool { return methods[i].MethodName < methods[j].MethodName })
+}
+
+// CollectImports merges the imports of methods with extra, given as import
+// path -> name, into a deduplicated import block sorted by path
+func CollectImports(methods []Method, extra map[string]string) []Import {
+	importNames := make(map[string]string)
+	for _, method := range methods {
+		for path, name := range method.Imports {
+			importNames[path] = name
+		}
+	}
+	for path, name := range extra {
+		importNames[path] = name
+	}
+
+	imports := make([]Import, 0, len(importNames))
+	for path, name := range importNames {
+		imports = append(imports, Import{Name: name, Path: path})
+	}
+	sort.Slice(imports, func(i, j int) bool { return imports[i].Path < imports[j].Path })
+	return imports
+}
@@ -0,0 +1,94 @@
+package duckimpl
+
+import (
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/gcexportdata"
+	"golang.org/x/tools/go/packages"
+)
+
+// ParseInterfaceFromExportData loads the package declaring interfaceName from
+// compiled export data, such as an .a or .x file produced by a build system,
+// instead of from source. Parameter names are synthesized where the export
+// data does not record them.
+func ParseInterfaceFromExportData(exportPath, interfaceName string) (Interface, error) {
+	// Handle potentially qualified interface name (package.Interface)
+	pkgPath, intName := "", interfaceName
+	if parts := SplitRight(interfaceName, "."); len(parts) > 1 {
+		pkgPath, intName = parts[0], parts[1]
+	}
+	local := pkgPath == ""
+	if local {
+		// export data of a local package; its path only needs to be unique
+		pkgPath = strings.TrimSuffix(filepath.Base(exportPath), filepath.Ext(exportPath))
+	}
+
+	debugLog("Loading export data for %s from %s\n", pkgPath, exportPath)
+	trace("export data", map[string]interface{}{"path": exportPath, "package": pkgPath})
+
+	file, err := os.Open(exportPath)
+	if err != nil {
+		return Interface{}, errorf(ErrInvalidPackage, "could not open export data: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := gcexportdata.NewReader(file)
+	if err != nil {
+		return Interface{}, errorf(ErrInvalidPackage, "could not read export data %s: %v", exportPath, err)
+	}
+
+	typesPkg, err := gcexportdata.Read(reader, token.NewFileSet(), make(map[string]*types.Package), pkgPath)
+	if err != nil {
+		return Interface{}, errorf(ErrInvalidPackage, "could not decode export data %s: %v", exportPath, err)
+	}
+
+	pkg := &packages.Package{
+		Name:    typesPkg.Name(),
+		PkgPath: typesPkg.Path(),
+		Types:   typesPkg,
+	}
+	return interfaceFromPackage(pkg, local, intName, interfaceName, pkgPath)
+}
+
+var (
+	exportDataOnce     sync.Once
+	exportDataReadable bool
+)
+
+// canReadExportData reports whether the export data the go command builds,
+// run in dir, can be decoded. A toolchain newer than the x/tools duck-impl was
+// built with may write a format it cannot read, on which packages.Load aborts
+// the process instead of reporting an error.
+func canReadExportData(dir string) bool {
+	exportDataOnce.Do(func() {
+		cmd := exec.Command("go", "list", "-export", "-f", "{{.Export}}", "errors")
+		cmd.Dir = dir
+		output, err := cmd.Output()
+		if err != nil {
+			debugLog("Cannot build export data: %v\n", err)
+			return
+		}
+		file, err := os.Open(strings.TrimSpace(string(output)))
+		if err != nil {
+			debugLog("Cannot open export data: %v\n", err)
+			return
+		}
+		defer file.Close()
+		reader, err := gcexportdata.NewReader(file)
+		if err == nil {
+			_, err = gcexportdata.Read(reader, token.NewFileSet(), make(map[string]*types.Package), "errors")
+		}
+		if err != nil {
+			infoLog("Cannot read the export data of the go command, type-checking dependencies from source: %v\n", err)
+			return
+		}
+		exportDataReadable = true
+	})
+	return exportDataReadable
+}
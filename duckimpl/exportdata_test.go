@@ -0,0 +1,68 @@
+package duckimpl
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/gcexportdata"
+)
+
+func TestParseInterfaceFromExportData(t *testing.T) {
+	const src = `package store
+
+type Store interface {
+	Get(key string) ([]byte, error)
+	Put(string, []byte) error
+}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "store.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := new(types.Config).Check("example.com/store", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// export data as a build system would hand it over, without sources, in
+	// the archive the compiler writes; the go command of this toolchain may
+	// write a format newer than x/tools reads
+	var data bytes.Buffer
+	if err := gcexportdata.Write(&data, fset, pkg); err != nil {
+		t.Fatal(err)
+	}
+	pkgdef := "go object linux amd64 devel X:none\n$$B\n" + data.String() + "\n$$\n"
+	archive := fmt.Sprintf("!<arch>\n%-16s%-12d%-6d%-6d%-8o%-10d`\n%s", "__.PKGDEF", 0, 0, 0, 0o644, len(pkgdef), pkgdef)
+	exportPath := filepath.Join(t.TempDir(), "store.a")
+	if err := os.WriteFile(exportPath, []byte(archive), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	iface, err := ParseInterfaceFromExportData(exportPath, "example.com/store.Store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface.PkgPath != "example.com/store" {
+		t.Errorf("PkgPath = %q, want example.com/store", iface.PkgPath)
+	}
+	want := []string{
+		"Get(key string) ([]byte, error)",
+		"Put(arg0 string, arg1 []byte) error",
+	}
+	if len(iface.Methods) != len(want) {
+		t.Fatalf("methods = %v, want %v", iface.Methods, want)
+	}
+	for i, method := range iface.Methods {
+		if got := method.String(); got != want[i] {
+			t.Errorf("method %d = %s, want %s", i, got, want[i])
+		}
+	}
+}
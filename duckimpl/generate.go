@@ -0,0 +1,1170 @@
+package duckimpl
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Method signature formatting functions
+func formatMethodParams(params []string) string {
+	if len(params) == 0 {
+		return "()"
+	}
+	return "(" + strings.Join(params, ", ") + ")"
+}
+
+// formatMethodResults renders a result list. A single unnamed result is
+// written bare, while named results like (err error) must keep their parens.
+func formatMethodResults(results []string) string {
+	if len(results) == 0 {
+		return ""
+	}
+	if len(results) == 1 && !isNamedResult(results[0]) {
+		return " " + results[0]
+	}
+	return " (" + strings.Join(results, ", ") + ")"
+}
+
+// isNamedResult reports whether result is of the form "name type". Types
+// that contain spaces themselves, like "chan int" or "func(a int) error",
+// never start with a plain identifier followed by a space.
+func isNamedResult(result string) bool {
+	name, _, found := strings.Cut(result, " ")
+	return found && token.IsIdentifier(name) && !token.IsKeyword(name)
+}
+
+// generatedMarker is the comment tooling uses to recognize generated files
+const generatedMarker = "// Code generated by duck-impl; DO NOT EDIT."
+
+// generatedMarkerPattern matches any marker following the convention at
+// https://go.dev/s/generatedcode
+var generatedMarkerPattern = regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.$`)
+
+// ownMarkerPattern matches the markers of the files duck-impl generates
+var ownMarkerPattern = regexp.MustCompile(`(?m)^// Code generated by duck-impl\b.* DO NOT EDIT\.$`)
+
+// packageClausePattern matches the start of the package clause
+var packageClausePattern = regexp.MustCompile(`(?m)^package `)
+
+// fileHead returns the part of src before the package clause, the only place
+// tools like ast.IsGenerated look for a generated-code marker
+func fileHead(src []byte) []byte {
+	if loc := packageClausePattern.FindIndex(src); loc != nil {
+		return src[:loc[0]]
+	}
+	return src
+}
+
+// ensureGeneratedMarker prepends the generated-code marker to src unless a
+// marker already precedes the package clause. Only custom templates can
+// leave it out.
+func ensureGeneratedMarker(src []byte) []byte {
+	if generatedMarkerPattern.Match(fileHead(src)) {
+		return src
+	}
+	return append([]byte(generatedMarker+"\n\n"), src...)
+}
+
+const tmpl = `{{with buildConstraint}}{{.}}
+
+{{end}}{{header}}
+
+package {{.PackageName}}
+{{- with .Imports}}
+
+import (
+{{- range $i, $group := importGroups}}
+{{- if $i}}
+{{- "\n"}}
+{{- end}}
+{{- range $group}}
+	{{with .Alias}}{{.}} {{end}}"{{.Path}}"
+{{- end}}
+{{- end}}
+)
+{{- end}}
+
+type {{typeName}}{{typeParams}} struct{{if emptyStruct}}{}{{else}} {
+{{- if .ThreadSafe}}
+	mu sync.Mutex
+{{- end}}
+{{- if or .FutureProof .Fallback}}
+	{{.InterfaceType}}{{typeArgs}}
+{{- end}}
+{{- range .Delegates}}
+	{{.}}
+{{- end}}
+{{- range .Methods}}
+{{- range docLines .Doc}}
+	{{.}}
+{{- end}}
+	{{.MethodName|fieldName}} func{{formatParams .Parameters}}{{formatResults .Results}}
+{{- end}}
+{{- if .Spy}}
+{{range .Methods}}
+	{{.MethodName}}Calls []{{spyRecord .}}
+{{- end}}
+{{- end}}
+}
+{{- end}}
+
+{{- range methodGroups}}
+{{- with .Embedded}}
+
+// Methods from {{.}}
+{{- end}}
+{{- range .Methods}}
+
+func ({{receiver $.InterfaceName}} {{receiverType}}) {{.MethodName}}{{formatParams .Parameters}}{{formatResults .Results}} {
+	{{- if $.ThreadSafe}}
+	{{receiver $.InterfaceName}}.mu.Lock()
+	defer {{receiver $.InterfaceName}}.mu.Unlock()
+	{{- end}}
+	{{- if $.Spy}}
+	{{receiver $.InterfaceName}}.{{.MethodName}}Calls = append({{receiver $.InterfaceName}}.{{.MethodName}}Calls, {{spyRecord .}}{{"{"}}{{spyArgs .}}{{"}"}})
+	{{- end}}
+	{{- if $.Fallback}}
+	if {{receiver $.InterfaceName}}.{{.MethodName|fieldName}} != nil {
+		{{callBody .}}
+		{{- if not .Returns}}
+		return
+		{{- end}}
+	}
+	{{if .Returns}}return {{end}}{{receiver $.InterfaceName}}.{{embedded $.InterfaceType}}.{{.MethodName}}{{callParams .Parameters}}
+	{{- else}}
+	{{- if $.FutureProof}}
+	if {{receiver $.InterfaceName}}.{{.MethodName|fieldName}} == nil {
+		panic("{{$.StructName}}: method {{.MethodName}} is not implemented")
+	}
+	{{- else if $.ZeroStub}}
+	if {{receiver $.InterfaceName}}.{{.MethodName|fieldName}} == nil {
+		return{{if not (resultNames .)}}{{with zeroValues .Returns}} {{.}}{{end}}{{end}}
+	}
+	{{- end}}
+	{{- if and (or $.FutureProof $.ZeroStub) (resultNames .)}}
+	{{resultNames .}} = {{receiver $.InterfaceName}}.{{.MethodName|fieldName}}{{callParams .Parameters}}
+	return
+	{{- else}}
+	{{callBody .}}
+	{{- end}}
+	{{- end}}
+}
+{{- end}}
+{{- end}}
+
+{{- if .Verify}}
+
+// Verify reports every method of {{clean .InterfaceName}} that has no implementation
+{{- if .Spy}}, or
+// that was never called, so call it once the code under test is done
+{{- end}}
+func ({{receiver .InterfaceName}} {{receiverType}}) Verify(t testing.TB) {
+	t.Helper()
+	{{- if and .Spy .ThreadSafe}}
+	{{receiver .InterfaceName}}.mu.Lock()
+	defer {{receiver .InterfaceName}}.mu.Unlock()
+	{{- end}}
+{{- range .Methods}}
+	if {{receiver $.InterfaceName}}.{{.MethodName|fieldName}} == nil {
+		t.Errorf("{{$.StructName}}: method {{.MethodName}} is not implemented")
+	}
+	{{- if $.Spy}}
+	if len({{receiver $.InterfaceName}}.{{.MethodName}}Calls) == 0 {
+		t.Errorf("{{$.StructName}}: method {{.MethodName}} was never called")
+	}
+	{{- end}}
+{{- end}}
+}
+{{- end}}
+
+{{- if not .NamedStruct}}
+
+type {{.StructName}}{{typeParams}} = _{{clean .InterfaceName}}_{{typeArgs}}
+{{- end}}
+
+{{- if .Options}}
+
+// Option sets the implementation of a method of {{.StructName}}
+type Option{{typeParams}} func(*{{.StructName}}{{typeArgs}})
+
+// New returns an implementation of {{clean .InterfaceName}} with the methods set by opts
+func New{{typeParams}}(opts ...Option{{typeArgs}}) {{if pointerReceiver}}*{{end}}{{.StructName}}{{typeArgs}} {
+	var impl {{.StructName}}{{typeArgs}}
+	for _, opt := range opts {
+		opt(&impl)
+	}
+	return {{if pointerReceiver}}&{{end}}impl
+}
+{{- range .Methods}}
+
+// With{{.MethodName}} sets the implementation of {{.MethodName}}
+func With{{.MethodName}}{{typeParams}}(fn func{{formatParams .Parameters}}{{formatResults .Results}}) Option{{typeArgs}} {
+	return func(impl *{{$.StructName}}{{typeArgs}}) {
+		impl.{{.MethodName|fieldName}} = fn
+	}
+}
+{{- end}}
+{{- end}}
+{{- with .Implements}}
+
+var (
+{{- range .}}
+	_ {{.}} = {{if pointerReceiver}}(*{{$.StructName}})(nil){{else}}{{$.StructName}}{}{{end}}
+{{- end}}
+)
+{{- end}}
+`
+
+// decoratorTmpl generates a logging decorator around an implementation of the
+// interface in place of the function-field struct
+const decoratorTmpl = `{{with buildConstraint}}{{.}}
+
+{{end}}{{header}}
+
+package {{.PackageName}}
+
+import (
+{{- range $i, $group := importGroups}}
+{{- if $i}}
+{{- "\n"}}
+{{- end}}
+{{- range $group}}
+	{{with .Alias}}{{.}} {{end}}"{{.Path}}"
+{{- end}}
+{{- end}}
+)
+
+// {{.StructName}}Logger logs the arguments and results of every call to an implementation of {{clean .InterfaceName}}
+type {{.StructName}}Logger{{typeParams}} struct {
+	next {{.InterfaceType}}{{typeArgs}}
+	log  *log.Logger
+}
+
+// New{{.StructName}}Logger decorates next, logging its calls to logger
+func New{{.StructName}}Logger{{typeParams}}(next {{.InterfaceType}}{{typeArgs}}, logger *log.Logger) *{{.StructName}}Logger{{typeArgs}} {
+	return &{{.StructName}}Logger{{typeArgs}}{next: next, log: logger}
+}
+{{- range .Methods}}
+
+func ({{receiver $.InterfaceName}} *{{$.StructName}}Logger{{typeArgs}}) {{.MethodName}}{{formatParams .Parameters}}{{formatResults .Results}} {
+	{{receiver $.InterfaceName}}.log.Printf("{{.MethodName}}({{logVerbs (len .Params)}})"{{range .Params}}, {{.Name}}{{end}})
+	{{- if .Returns}}
+	{{resultVars .}} := {{receiver $.InterfaceName}}.next.{{.MethodName}}{{callParams .Parameters}}{{wrapError .}}
+	{{receiver $.InterfaceName}}.log.Printf("{{.MethodName}} returned {{logVerbs (len .Returns)}}", {{resultVars .}})
+	return {{resultVars .}}
+	{{- else}}
+	{{receiver $.InterfaceName}}.next.{{.MethodName}}{{callParams .Parameters}}
+	{{receiver $.InterfaceName}}.log.Printf("{{.MethodName}} returned")
+	{{- end}}
+}
+{{- end}}
+`
+
+// importGroups splits the imports into the groups goimports separates with
+// a blank line: the standard library, other packages, and the packages
+// matching one of the comma separated LocalPrefix prefixes. Empty groups are
+// left out.
+func (g *Generator) importGroups() [][]Import {
+	var std, other, local []Import
+	for _, imp := range g.Imports {
+		switch {
+		case g.isLocalImport(imp.Path):
+			local = append(local, imp)
+		case !strings.Contains(strings.SplitN(imp.Path, "/", 2)[0], "."):
+			std = append(std, imp)
+		default:
+			other = append(other, imp)
+		}
+	}
+
+	var groups [][]Import
+	for _, group := range [][]Import{std, other, local} {
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// isLocalImport reports whether importPath starts with one of the comma
+// separated LocalPrefix prefixes
+func (g *Generator) isLocalImport(importPath string) bool {
+	for _, prefix := range strings.Split(g.LocalPrefix, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" && strings.HasPrefix(importPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// timingTmpl generates a decorator timing the calls to an implementation of
+// the interface in place of the function-field struct
+const timingTmpl = `{{with buildConstraint}}{{.}}
+
+{{end}}{{header}}
+
+package {{.PackageName}}
+
+import (
+{{- range $i, $group := importGroups}}
+{{- if $i}}
+{{- "\n"}}
+{{- end}}
+{{- range $group}}
+	{{with .Alias}}{{.}} {{end}}"{{.Path}}"
+{{- end}}
+{{- end}}
+)
+
+// {{.StructName}}Timing reports the duration of every call to an implementation of {{clean .InterfaceName}}
+type {{.StructName}}Timing{{typeParams}} struct {
+	next    {{.InterfaceType}}{{typeArgs}}
+	observe func(method string, d time.Duration)
+}
+
+// New{{.StructName}}Timing decorates next, passing the duration of each call to observe
+func New{{.StructName}}Timing{{typeParams}}(next {{.InterfaceType}}{{typeArgs}}, observe func(method string, d time.Duration)) *{{.StructName}}Timing{{typeArgs}} {
+	return &{{.StructName}}Timing{{typeArgs}}{next: next, observe: observe}
+}
+{{- range .Methods}}
+
+func ({{receiver $.InterfaceName}} *{{$.StructName}}Timing{{typeArgs}}) {{.MethodName}}{{formatParams .Parameters}}{{formatResults .Results}} {
+	defer func(start time.Time) { {{receiver $.InterfaceName}}.observe("{{.MethodName}}", time.Since(start)) }(time.Now())
+	{{- if wrapError .}}
+	{{resultVars .}} := {{receiver $.InterfaceName}}.next.{{.MethodName}}{{callParams .Parameters}}{{wrapError .}}
+	return {{resultVars .}}
+	{{- else}}
+	{{if hasResults .Results}}return {{end}}{{receiver $.InterfaceName}}.next.{{.MethodName}}{{callParams .Parameters}}
+	{{- end}}
+}
+{{- end}}
+`
+
+// resultNames joins the names of the results of method, like "n, err", or
+// returns "" unless every result is named, as normalizeResults ensures when
+// any of them is
+func resultNames(method Method) string {
+	names := make([]string, len(method.Returns))
+	for i, result := range method.Returns {
+		if result.Name == "" {
+			return ""
+		}
+		names[i] = result.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// funcAdapterTmpl generates a func type implementing a single-method
+// interface by calling itself, like http.HandlerFunc, in place of the
+// function-field struct
+const funcAdapterTmpl = `{{with buildConstraint}}{{.}}
+
+{{end}}{{header}}
+
+package {{.PackageName}}
+{{- with .Imports}}
+
+import (
+{{- range $i, $group := importGroups}}
+{{- if $i}}
+{{- "\n"}}
+{{- end}}
+{{- range $group}}
+	{{with .Alias}}{{.}} {{end}}"{{.Path}}"
+{{- end}}
+{{- end}}
+)
+{{- end}}
+{{- range .Methods}}
+
+// {{$.StructName}}Func adapts an ordinary function to {{clean $.InterfaceName}}
+type {{$.StructName}}Func{{typeParams}} func{{formatParams .Parameters}}{{formatResults .Results}}
+
+// {{.MethodName}} calls the function itself
+func ({{receiver $.InterfaceName}} {{$.StructName}}Func{{typeArgs}}) {{.MethodName}}{{formatParams .Parameters}}{{formatResults .Results}} {
+	{{if .Returns}}return {{end}}{{receiver $.InterfaceName}}{{callParams .Parameters}}
+}
+{{- end}}
+`
+
+// unimplementedTmpl generates an embeddable base implementing every method
+// of the interface with a panic, in place of the function-field struct
+const unimplementedTmpl = `{{with buildConstraint}}{{.}}
+
+{{end}}{{header}}
+
+package {{.PackageName}}
+{{- with .Imports}}
+
+import (
+{{- range $i, $group := importGroups}}
+{{- if $i}}
+{{- "\n"}}
+{{- end}}
+{{- range $group}}
+	{{with .Alias}}{{.}} {{end}}"{{.Path}}"
+{{- end}}
+{{- end}}
+)
+{{- end}}
+
+// Unimplemented{{.StructName}} implements every method of {{clean .InterfaceName}} by panicking.
+// Embed it to implement {{clean .InterfaceName}} while overriding only some methods.
+type Unimplemented{{.StructName}}{{typeParams}} struct{}
+{{- range .Methods}}
+
+func (Unimplemented{{$.StructName}}{{typeArgs}}) {{.MethodName}}{{formatParams .Parameters}}{{formatResults .Results}} {
+	panic("unimplemented: {{.MethodName}}")
+}
+{{- end}}
+`
+
+// logVerbs renders n comma separated %v verbs for the decorator's log lines
+func logVerbs(n int) string {
+	return strings.TrimSuffix(strings.Repeat("%v, ", n), ", ")
+}
+
+// wrapError renders the statement wrapping the error returned last by a call
+// forwarded by the decorators, held in the last of resultVars, with the method
+// it came from. It is "" unless WrapErrors is set and the method returns an
+// error last.
+func (g *Generator) wrapError(method Method) string {
+	last := len(method.Returns) - 1
+	if !g.WrapErrors || last < 0 || method.Returns[last].Type != "error" {
+		return ""
+	}
+	err := resultVarNames(method)[last]
+	return fmt.Sprintf("\n\tif %s != nil {\n\t\t%s = fmt.Errorf(\"%s.%s: %%w\", %s)\n\t}", err, err, g.StructName, method.MethodName, err)
+}
+
+// resultVars joins the resultVarNames of method, like "r0, r1"
+func resultVars(method Method) string {
+	return strings.Join(resultVarNames(method), ", ")
+}
+
+// resultVarNames names the variables the decorators declare to hold the
+// results of a forwarded call, like r0 and r1, so that none of them
+// redeclares a parameter or a named result of method
+func resultVarNames(method Method) []string {
+	taken := boundNames(method.Params, method.Returns)
+	names := make([]string, len(method.Returns))
+	for i := range method.Returns {
+		names[i] = freshName(fmt.Sprintf("r%d", i), taken)
+	}
+	return names
+}
+
+// boundNames returns the set of the names declared by the parameter lists,
+// leaving out blank and unnamed ones
+func boundNames(lists ...[]Param) map[string]bool {
+	names := make(map[string]bool)
+	for _, list := range lists {
+		for _, param := range list {
+			if param.Name != "" && param.Name != "_" {
+				names[param.Name] = true
+			}
+		}
+	}
+	return names
+}
+
+// freshName returns name, suffixed with underscores until it is not in
+// taken, and adds it to taken
+func freshName(name string, taken map[string]bool) string {
+	for taken[name] {
+		name += "_"
+	}
+	taken[name] = true
+	return name
+}
+
+// cleanName strips the package qualifier from an interface name
+func cleanName(s string) string {
+	parts := strings.Split(s, ".")
+	if len(parts) > 1 {
+		return parts[len(parts)-1]
+	}
+	return s
+}
+
+// embeddedName returns the name of the field embedding the interface type s:
+// its type name without the package qualifier or the type arguments, which
+// may be qualified themselves
+func embeddedName(s string) string {
+	if i := strings.Index(s, "["); i >= 0 {
+		s = s[:i]
+	}
+	return cleanName(s)
+}
+
+// receiverName returns the receiver identifier used in generated methods,
+// preferring the -receiver flag value over the derived <interface>_impl name.
+// A derived name is suffixed with underscores until it no longer collides
+// with an imported package or a parameter name.
+func (g *Generator) receiverName(interfaceName string) string {
+	if g.Receiver != "" {
+		return g.Receiver
+	}
+
+	reserved := g.reservedNames()
+	name := strings.ToLower(cleanName(interfaceName)) + "_impl"
+	for reserved[name] {
+		name += "_"
+	}
+	return name
+}
+
+// reservedNames returns the identifiers visible inside generated methods:
+// the names of imported packages and of all method parameters
+func (g *Generator) reservedNames() map[string]bool {
+	reserved := make(map[string]bool)
+	for _, imp := range g.Imports {
+		reserved[imp.Name] = true
+	}
+	for _, method := range g.Methods {
+		for _, param := range method.Params {
+			reserved[param.Name] = true
+		}
+	}
+	return reserved
+}
+
+// headerComment returns the comment block emitted before the package clause.
+// Lines of a custom Header not already commented are turned into comments,
+// and the generated-code marker is prepended when the header lacks one.
+func (g *Generator) headerComment() string {
+	header := strings.TrimRight(g.Header, "\n")
+	if header == "" {
+		return generatedMarker
+	}
+
+	lines := strings.Split(header, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "//") {
+			lines[i] = strings.TrimRight("// "+line, " ")
+		}
+	}
+	header = strings.Join(lines, "\n")
+
+	// keep duck-impl's marker, which Generate requires to overwrite the file
+	if !ownMarkerPattern.MatchString(header) {
+		header = generatedMarker + "\n" + header
+	}
+	return header
+}
+
+// buildConstraint renders BuildTags as a //go:build line followed by the
+// equivalent // +build lines for older toolchains, or "" without build tags
+func (g *Generator) buildConstraint() (string, error) {
+	if g.BuildTags == "" {
+		return "", nil
+	}
+
+	expr, err := constraint.Parse("//go:build " + g.BuildTags)
+	if err != nil {
+		return "", fmt.Errorf("invalid build tags %q: %v", g.BuildTags, err)
+	}
+	plusBuild, err := constraint.PlusBuildLines(expr)
+	if err != nil {
+		return "", fmt.Errorf("invalid build tags %q: %v", g.BuildTags, err)
+	}
+
+	lines := append([]string{"//go:build " + expr.String()}, plusBuild...)
+	return strings.Join(lines, "\n"), nil
+}
+
+// typeName returns the name of the generated struct type: StructName under
+// NamedStruct, otherwise _Interface_, which StructName is an alias of
+func (g *Generator) typeName() string {
+	if g.NamedStruct {
+		return g.StructName
+	}
+	return "_" + cleanName(g.InterfaceName) + "_"
+}
+
+// pointerReceiver reports whether generated methods have pointer receivers,
+// as requested by PtrReceiver and needed by Spy and ThreadSafe so that
+// methods can record their calls and share the mutex
+func (g *Generator) pointerReceiver() bool {
+	return g.PtrReceiver || g.Spy || g.ThreadSafe
+}
+
+// receiverType renders the receiver type of generated methods
+func (g *Generator) receiverType() string {
+	recv := g.typeName() + g.formatTypeArgs()
+	if g.pointerReceiver() {
+		return "*" + recv
+	}
+	return recv
+}
+
+// emptyStruct reports whether the generated struct has no fields at all, as
+// for the empty interface, so that it renders as struct{}
+func (g *Generator) emptyStruct() bool {
+	return len(g.Methods) == 0 && len(g.Delegates) == 0 && !g.FutureProof && !g.Fallback && !g.ThreadSafe
+}
+
+// spyRecord renders the struct type recording a call to method under Spy,
+// with an exported field per parameter, like struct{ P []byte }. Parameters
+// differing only in the case of their first letter, like p and P, get
+// distinct fields.
+func spyRecord(method Method) string {
+	if len(method.Params) == 0 {
+		return "struct{}"
+	}
+
+	taken := make(map[string]bool, len(method.Params))
+	fields := make([]string, len(method.Params))
+	for i, param := range method.Params {
+		typ := param.Type
+		if strings.HasPrefix(typ, "...") {
+			typ = "[]" + strings.TrimPrefix(typ, "...")
+		}
+		first, size := utf8.DecodeRuneInString(param.Name)
+		fields[i] = freshName(string(unicode.ToUpper(first))+param.Name[size:], taken) + " " + typ
+	}
+	return "struct{ " + strings.Join(fields, "; ") + " }"
+}
+
+// spyArgs renders the parameter names of method as the values of its
+// spyRecord
+func spyArgs(method Method) string {
+	names := make([]string, len(method.Params))
+	for i, param := range method.Params {
+		names[i] = param.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// formatTypeParams renders the type parameter list of the generated struct,
+// like [K comparable, V any], or "" for non-generic interfaces
+func (g *Generator) formatTypeParams() string {
+	if len(g.TypeParams) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(joinParams(g.TypeParams), ", ") + "]"
+}
+
+// formatTypeArgs renders the type parameters as arguments instantiating the
+// generated struct, like [K, V]
+func (g *Generator) formatTypeArgs() string {
+	if len(g.TypeParams) == 0 {
+		return ""
+	}
+	names := make([]string, len(g.TypeParams))
+	for i, tparam := range g.TypeParams {
+		names[i] = tparam.Name
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+// zeroValues renders the zero values of results as a return list
+func zeroValues(results []Param) string {
+	values := make([]string, len(results))
+	for i, result := range results {
+		values[i] = zeroValue(result.Type)
+	}
+	return strings.Join(values, ", ")
+}
+
+// zeroValue returns an expression for the zero value of typ, a type as
+// written in generated code
+func zeroValue(typ string) string {
+	switch {
+	case strings.HasPrefix(typ, "*"), strings.HasPrefix(typ, "[]"), strings.HasPrefix(typ, "map["),
+		strings.HasPrefix(typ, "chan "), strings.HasPrefix(typ, "chan<- "), strings.HasPrefix(typ, "<-chan "),
+		strings.HasPrefix(typ, "func("), strings.HasPrefix(typ, "interface{"):
+		return "nil"
+	case strings.HasPrefix(typ, "["), strings.HasPrefix(typ, "struct{"):
+		// arrays and anonymous structs
+		return typ + "{}"
+	}
+
+	switch typ {
+	case "any", "error":
+		return "nil"
+	case "string":
+		return `""`
+	case "bool":
+		return "false"
+	case "int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+		"float32", "float64", "complex64", "complex128", "byte", "rune":
+		return "0"
+	}
+
+	// named types and type parameters, whose underlying type is not known here
+	return "*new(" + typ + ")"
+}
+
+// methodGroup is a run of generated methods promoted from the same embedded
+// interface, or the explicit methods when Embedded is empty
+type methodGroup struct {
+	Embedded string
+	Methods  []Method
+}
+
+// methodGroups splits the methods by the embedded interface they come from
+// when GroupByEmbedded is set. Explicit methods come first, followed by one
+// group per embedded interface in order of first appearance. Otherwise all
+// methods form a single group in their original order.
+func (g *Generator) methodGroups() []methodGroup {
+	if !g.GroupByEmbedded {
+		return []methodGroup{{Methods: g.Methods}}
+	}
+
+	groups := []methodGroup{{}}
+	index := map[string]int{"": 0}
+	for _, method := range g.Methods {
+		i, ok := index[method.Embedded]
+		if !ok {
+			i = len(groups)
+			index[method.Embedded] = i
+			groups = append(groups, methodGroup{Embedded: method.Embedded})
+		}
+		groups[i].Methods = append(groups[i].Methods, method)
+	}
+	return groups
+}
+
+// fieldName returns the name of the function field implementing the method
+// called methodName: the method name with a lowercased first character and
+// FieldSuffix appended or, without a FieldSuffix, an underscore appended only
+// when that is a keyword, as for Map or Range, or when the method is
+// unexported already, so the field and method differ
+func (g *Generator) fieldName(methodName string) string {
+	first, size := utf8.DecodeRuneInString(methodName)
+	name := string(unicode.ToLower(first)) + methodName[size:]
+	if g.FieldSuffix != "" {
+		return name + g.FieldSuffix
+	}
+	if token.IsKeyword(name) || name == methodName {
+		name += "_"
+	}
+	return name
+}
+
+// docLines turns a doc comment back into "//" prefixed comment lines
+func docLines(doc string) []string {
+	doc = strings.TrimRight(doc, "\n")
+	if doc == "" {
+		return nil
+	}
+
+	lines := strings.Split(doc, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = "//"
+		} else {
+			lines[i] = "// " + line
+		}
+	}
+	return lines
+}
+
+// Render executes the code template and returns the generated source,
+// formatted with gofmt under Gofmt. It does not write OutputFile, and may be
+// called repeatedly.
+func (g *Generator) Render() ([]byte, error) {
+	if g.PackageName == "" {
+		return nil, errorf(ErrInvalidPackage, "no package name set for %s", g.OutputFile)
+	}
+	if g.Receiver != "" && g.reservedNames()[g.Receiver] {
+		return nil, fmt.Errorf("receiver %q collides with an imported package or parameter name", g.Receiver)
+	}
+	methods, err := coalesceMethods(g.Methods, g.InterfaceName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range methods {
+		methods[i].Returns = normalizeResults(methods[i].Params, methods[i].Returns)
+	}
+	g.Methods = methods
+
+	// Use the built-in template unless a custom one was given
+	text := tmpl
+	if g.Decorator {
+		text = decoratorTmpl
+	}
+	if g.Timing {
+		text = timingTmpl
+	}
+	if g.Unimplemented {
+		text = unimplementedTmpl
+	}
+	if g.FuncAdapter {
+		if len(g.Methods) != 1 {
+			return nil, fmt.Errorf("a func adapter needs an interface with exactly one method, %s has %d", cleanName(g.InterfaceName), len(g.Methods))
+		}
+		text = funcAdapterTmpl
+	}
+	if g.Template != "" {
+		text = g.Template
+	}
+
+	// Create template
+	t, err := template.New("codegen").Funcs(g.funcMap()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template: %v", err)
+	}
+
+	// Execute template
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, g); err != nil {
+		return nil, fmt.Errorf("could not execute template: %v", err)
+	}
+
+	src := ensureGeneratedMarker(buf.Bytes())
+	if g.Gofmt {
+		formatted, err := format.Source(src)
+		if err != nil {
+			return nil, fmt.Errorf("could not format generated code: %v", err)
+		}
+		src = formatted
+	}
+	return src, nil
+}
+
+// coalesceMethods drops repeated methods with identical signatures, and fails
+// on methods sharing a name with different signatures, which would otherwise
+// generate conflicting fields. Methods not promoted from an embedded interface
+// are attributed to interfaceName.
+func coalesceMethods(methods []Method, interfaceName string) ([]Method, error) {
+	source := func(method Method) string {
+		if method.Embedded != "" {
+			return method.Embedded
+		}
+		return interfaceName
+	}
+
+	index := make(map[string]int, len(methods))
+	coalesced := make([]Method, 0, len(methods))
+	for _, method := range methods {
+		i, ok := index[method.MethodName]
+		if !ok {
+			index[method.MethodName] = len(coalesced)
+			coalesced = append(coalesced, method)
+			continue
+		}
+		if first := coalesced[i]; signature(first) != signature(method) {
+			return nil, fmt.Errorf("method %s has conflicting signatures %s from %s and %s from %s",
+				method.MethodName, signature(first), source(first), signature(method), source(method))
+		}
+	}
+	return coalesced, nil
+}
+
+// normalizeResults names every result when only some of them are named, as
+// Go requires, synthesizing names like r1 for the unnamed and blank ones
+// that clash with none of the params
+func normalizeResults(params, results []Param) []Param {
+	named := false
+	for _, result := range results {
+		if result.Name != "" {
+			named = true
+		}
+	}
+	if !named {
+		return results
+	}
+
+	taken := boundNames(params, results)
+	normalized := make([]Param, len(results))
+	for i, result := range results {
+		// a blank result cannot be assigned the value to return, like an unnamed one
+		if result.Name == "" || result.Name == "_" {
+			result.Name = freshName(fmt.Sprintf("r%d", i), taken)
+		}
+		normalized[i] = result
+	}
+	return normalized
+}
+
+// funcMap returns the helpers available to the built-in and custom templates
+func (g *Generator) funcMap() template.FuncMap {
+	return template.FuncMap{
+		"clean":           cleanName,
+		"embedded":        embeddedName,
+		"receiver":        g.receiverName,
+		"docLines":        docLines,
+		"zeroValues":      zeroValues,
+		"methodGroups":    g.methodGroups,
+		"header":          g.headerComment,
+		"buildConstraint": g.buildConstraint,
+		"typeParams":      g.formatTypeParams,
+		"typeName":        g.typeName,
+		"receiverType":    g.receiverType,
+		"pointerReceiver": g.pointerReceiver,
+		"emptyStruct":     g.emptyStruct,
+		"spyRecord":       spyRecord,
+		"logVerbs":        logVerbs,
+		"importGroups":    g.importGroups,
+		"resultVars":      resultVars,
+		"wrapError":       g.wrapError,
+		"resultNames":     resultNames,
+		"spyArgs":         spyArgs,
+		"typeArgs":        g.formatTypeArgs,
+		"lowerInitalChar": func(s string) string { return strings.ToLower(s[:1]) + s[1:] },
+		"fieldName":       g.fieldName,
+		"toLower":         strings.ToLower,
+		"formatParams":    formatMethodParams,
+		"formatResults":   formatMethodResults,
+		"callParams":      callParams,
+		"callBody":        g.callBody,
+		"hasResults": func(results []string) bool {
+			return len(results) > 0
+		},
+	}
+}
+
+// callParams renders the names of params, as "name type" strings, as the
+// arguments of a call, spreading a variadic last parameter like args...
+func callParams(params []string) string {
+	if len(params) == 0 {
+		return "()"
+	}
+
+	paramNames := make([]string, len(params))
+	for i, param := range params {
+		parts := strings.SplitN(param, " ", 2)
+		paramNames[i] = parts[0]
+		if len(parts) == 2 && strings.HasPrefix(parts[1], "...") {
+			paramNames[i] += "..."
+		}
+	}
+
+	return "(" + strings.Join(paramNames, ", ") + ")"
+}
+
+// callBody renders the default body of a generated method, a call of its
+// function field returning the results, if any, like
+// return impl.read(p)
+func (g *Generator) callBody(method Method) string {
+	call := g.receiverName(g.InterfaceName) + "." + g.fieldName(method.MethodName) + callParams(method.Parameters())
+	if len(method.Returns) == 0 {
+		return call
+	}
+	return "return " + call
+}
+
+// WriteTo writes the generated source to w, implementing io.WriterTo
+func (g *Generator) WriteTo(w io.Writer) (int64, error) {
+	src, err := g.Render()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(src)
+	return int64(n), err
+}
+
+// Generate writes the generated source to OutputFile. An existing file is
+// only overwritten if duck-impl's generated-code marker precedes its package
+// clause, or if Force is set, so files of other generators are left alone.
+func (g *Generator) Generate() error {
+	if !g.Force {
+		existing, err := os.ReadFile(g.OutputFile)
+		if err == nil && !ownMarkerPattern.Match(fileHead(existing)) {
+			return errorf(ErrWrite, "refusing to overwrite %s: it exists and was not generated by duck-impl", g.OutputFile)
+		}
+	}
+
+	// Render before creating the output file, so a failure leaves it untouched
+	src, err := g.Render()
+	if err != nil {
+		return err
+	}
+
+	// Create output file
+	file, err := os.Create(g.OutputFile)
+	if err != nil {
+		return errorf(ErrWrite, "could not create output file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(src); err != nil {
+		return errorf(ErrWrite, "could not write output file: %v", err)
+	}
+
+	return nil
+}
+
+// Append adds the generated declarations to the end of OutputFile, an
+// existing file of the same package, instead of writing a file of their own.
+// The header and package clause are left out, and the imports the
+// declarations need are merged into those of the file.
+func (g *Generator) Append() error {
+	existing, err := os.ReadFile(g.OutputFile)
+	if err != nil {
+		return errorf(ErrWrite, "could not read output file: %v", err)
+	}
+	info, err := os.Stat(g.OutputFile)
+	if err != nil {
+		return errorf(ErrWrite, "could not read output file: %v", err)
+	}
+
+	src, err := g.Render()
+	if err != nil {
+		return err
+	}
+
+	merged, err := appendSource(g.OutputFile, existing, src)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(g.OutputFile, merged, info.Mode().Perm()); err != nil {
+		return errorf(ErrWrite, "could not write output file: %v", err)
+	}
+	return nil
+}
+
+// appendSource returns the source of the file filename, existing, followed by
+// the declarations of the generated source, with the imports of generated
+// that existing lacks added to its own
+func appendSource(filename string, existing, generated []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	dst, err := parser.ParseFile(fset, filename, existing, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", filename, err)
+	}
+	gen, err := parser.ParseFile(fset, "", generated, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse generated code: %v", err)
+	}
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+
+	if dst.Name.Name != gen.Name.Name {
+		return nil, errorf(ErrInvalidPackage, "%s is in package %s, not %s", filename, dst.Name.Name, gen.Name.Name)
+	}
+
+	// Appending twice would declare everything again
+	declared := declaredNames(dst)
+	for name := range declaredNames(gen) {
+		if declared[name] {
+			return nil, fmt.Errorf("%s already declares %s; remove the generated code before appending it again", filename, name)
+		}
+	}
+
+	// The names the file refers to its imports by, by import path
+	importNames := make(map[string]string)
+	for _, spec := range dst.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		importNames[importPath] = importName(spec, importPath)
+	}
+	var missing []string
+	for _, spec := range gen.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		name, ok := importNames[importPath]
+		if !ok {
+			missing = append(missing, string(generated[offset(spec.Pos()):offset(spec.End())]))
+			continue
+		}
+		if want := importName(spec, importPath); name != want {
+			return nil, fmt.Errorf("%s imports %s as %s, but the generated code refers to it as %s", filename, importPath, name, want)
+		}
+	}
+
+	// Add the missing imports to the last import declaration, parenthesizing
+	// it if needed, or in a declaration of their own after the package clause
+	var buf bytes.Buffer
+	if len(missing) == 0 {
+		buf.Write(existing)
+	} else {
+		specs := strings.Join(missing, "\n\t")
+		switch last := lastImportDecl(dst); {
+		case last == nil:
+			end := offset(dst.Name.End())
+			buf.Write(existing[:end])
+			buf.WriteString("\n\nimport (\n\t" + specs + "\n)")
+			buf.Write(existing[end:])
+		case last.Rparen.IsValid():
+			end := offset(last.Rparen)
+			buf.Write(existing[:end])
+			buf.WriteString("\t" + specs + "\n")
+			buf.Write(existing[end:])
+		default:
+			start, end := offset(last.Specs[0].Pos()), offset(last.End())
+			buf.Write(existing[:start])
+			buf.WriteString("(\n\t")
+			buf.Write(existing[start:end])
+			buf.WriteString("\n\t" + specs + "\n)")
+			buf.Write(existing[end:])
+		}
+	}
+
+	// The declarations follow the imports of the generated source
+	start := offset(gen.Name.End())
+	if last := lastImportDecl(gen); last != nil {
+		start = offset(last.End())
+	}
+	buf.WriteString("\n")
+	buf.Write(generated[start:])
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("could not format %s with the appended code: %v", filename, err)
+	}
+	return src, nil
+}
+
+// lastImportDecl returns the last import declaration of file, or nil
+func lastImportDecl(file *ast.File) *ast.GenDecl {
+	var last *ast.GenDecl
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.IMPORT {
+			last = decl
+		}
+	}
+	return last
+}
+
+// declaredNames returns the names of the package-level types, functions,
+// variables and constants declared by file, except _
+func declaredNames(file *ast.File) map[string]bool {
+	names := make(map[string]bool)
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				names[decl.Name.Name] = true
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					names[spec.Name.Name] = true
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						names[name.Name] = true
+					}
+				}
+			}
+		}
+	}
+	delete(names, "_")
+	return names
+}
+
+// importName returns the name a file refers to the package imported by spec
+// as, its explicit name or, as Import.Alias assumes, the last element of its path
+func importName(spec *ast.ImportSpec, importPath string) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	return path.Base(importPath)
+}
@@ -0,0 +1,552 @@
+package duckimpl
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata with the generated code")
+
+// fixturesDir holds the package declaring the local fixture interfaces
+const fixturesDir = "testdata/fixtures"
+
+// generate runs the parse and generate pipeline of duck-impl for the
+// interface called name, resolved in dir, generating into package pkgName.
+// The options adjust the Generator, like flags would.
+func generate(t *testing.T, dir, pkgName, structName, name string, options ...func(*Generator)) []byte {
+	t.Helper()
+	iface, err := ParseInterface(dir, name)
+	if err != nil {
+		t.Fatalf("ParseInterface(%s): %v", name, err)
+	}
+	return render(t, iface, EmbeddedFlatten, pkgName, structName, name, options...)
+}
+
+// render generates the code of the parsed iface, called name, like generate,
+// handling its embedded interfaces under mode
+func render(t *testing.T, iface Interface, mode, pkgName, structName, name string, options ...func(*Generator)) []byte {
+	t.Helper()
+	methods, delegates, imports := ApplyEmbeddedMode(iface.Methods, mode)
+	SortMethods(methods)
+	if imports == nil {
+		imports = make(map[string]string)
+	}
+	for path, pkgName := range iface.TypeParamImports {
+		imports[path] = pkgName
+	}
+
+	g := Generator{
+		StructName:    structName,
+		InterfaceName: name,
+		PackageName:   pkgName,
+		Gofmt:         true,
+		InterfaceType: cleanName(name),
+		TypeParams:    iface.TypeParams,
+		Methods:       methods,
+		Delegates:     delegates,
+		Imports:       CollectImports(methods, imports),
+	}
+	for _, option := range options {
+		option(&g)
+	}
+	src, err := g.Render()
+	if err != nil {
+		t.Fatalf("Render(%s): %v", name, err)
+	}
+	return src
+}
+
+// importing returns an option adding the standard library packages to the
+// imports, like the flags generating code that uses them do
+func importing(paths ...string) func(*Generator) {
+	return func(g *Generator) {
+		extra := make(map[string]string, len(g.Imports)+len(paths))
+		for _, imp := range g.Imports {
+			extra[imp.Path] = imp.Name
+		}
+		for _, path := range paths {
+			extra[path] = path
+		}
+		g.Imports = CollectImports(nil, extra)
+	}
+}
+
+// decorating generates the logging decorator, wrapping errors
+func decorating(g *Generator) {
+	g.Decorator = true
+	g.WrapErrors = true
+	importing("fmt", "log")(g)
+}
+
+// timing generates the timing decorator, wrapping errors
+func timing(g *Generator) {
+	g.Timing = true
+	g.WrapErrors = true
+	importing("fmt", "time")(g)
+}
+
+// spying records the calls
+func spying(g *Generator) {
+	g.Spy = true
+}
+
+// suffixing names the function fields like getFn
+func suffixing(g *Generator) {
+	g.FieldSuffix = "Fn"
+}
+
+// adapting generates the func adapter
+func adapting(g *Generator) {
+	g.FuncAdapter = true
+}
+
+// vet runs go vet on a module holding the fixtures package and the files
+func vet(t *testing.T, files map[string][]byte) {
+	t.Helper()
+	dir := t.TempDir()
+	fixtures, err := os.ReadFile(filepath.Join(fixturesDir, "fixtures.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["go.mod"] = []byte("module example.com/fixtures\n\ngo 1.24\n")
+	files["fixtures.go"] = fixtures
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), src, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("go", "vet", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated code does not compile: %v\n%s", err, out)
+	}
+}
+
+// goTest runs go test, with the flags, on a module holding the fixtures
+// package and the files, returning its output
+func goTest(t *testing.T, files map[string]string, flags ...string) ([]byte, error) {
+	t.Helper()
+	dir := t.TempDir()
+	fixtures, err := os.ReadFile(filepath.Join(fixturesDir, "fixtures.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["go.mod"] = "module example.com/fixtures\n\ngo 1.24\n"
+	files["fixtures.go"] = string(fixtures)
+	writeFiles(t, dir, files)
+
+	cmd := exec.Command("go", append(append([]string{"test"}, flags...), ".")...)
+	cmd.Dir = dir
+	return cmd.CombinedOutput()
+}
+
+func TestGenerateGolden(t *testing.T) {
+	tests := []struct {
+		name       string
+		structName string
+		iface      string
+		options    []func(*Generator)
+	}{
+		{"local", "StoreFuncs", "Store", nil},
+		{"reader", "ReaderFuncs", "io.Reader", nil},
+		{"embedded", "NamedReadCloserFuncs", "NamedReadCloser", nil},
+		{"variadic", "LoggerFuncs", "Logger", nil},
+		{"generic", "ContainerFuncs", "Container", nil},
+		{"blank_decorator", "BlankFuncs", "Blank", []func(*Generator){decorating}},
+		{"blank_timing", "BlankFuncs", "Blank", []func(*Generator){timing}},
+		{"spy", "StoreFuncs", "Store", []func(*Generator){spying}},
+		{"func_adapter", "Reader", "io.Reader", []func(*Generator){adapting}},
+		{"field_suffix", "StoreFuncs", "Store", []func(*Generator){suffixing}},
+		{"field_suffix_unicode", "ÜberFuncs", "Über", []func(*Generator){suffixing}},
+		{"spy_names", "CaserFuncs", "Caser", []func(*Generator){spying}},
+		{"shadow_decorator", "ShadowFuncs", "Shadow", []func(*Generator){decorating}},
+		{"shadow_timing", "ShadowFuncs", "Shadow", []func(*Generator){timing}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generate(t, fixturesDir, "fixtures", tt.structName, tt.iface, tt.options...)
+			checkGolden(t, tt.name, got)
+			vet(t, map[string][]byte{"gen.go": got})
+		})
+	}
+}
+
+// checkGolden compares the generated code with testdata/name.golden, which
+// go test -update rewrites
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("generated code differs from %s (run go test -update if intended):\n%s", golden, got)
+	}
+}
+
+func TestGenerateSameNamedImports(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":         "module example.com/m\n\ngo 1.22\n",
+		"api/v1/api.go":  "package v1\n\ntype Request struct{}\n",
+		"store/v1/db.go": "package v1\n\ntype Record struct{}\n",
+		"svc/svc.go":     "package svc\n\nimport (\n\tapi \"example.com/m/api/v1\"\n\tstore \"example.com/m/store/v1\"\n)\n\ntype Service interface {\n\tHandle(req api.Request) (store.Record, error)\n}\n",
+		"gen/doc.go":     "package gen\n",
+	})
+
+	src := generate(t, filepath.Join(dir, "gen"), "gen", "ServiceFuncs", "example.com/m/svc.Service")
+	if err := os.WriteFile(filepath.Join(dir, "gen", "gen.go"), src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("go", "vet", "./...")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated code does not compile: %v\n%s\n%s", err, out, src)
+	}
+}
+
+func TestGenerateThreadSafeRace(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated code with the race detector")
+	}
+	src := generate(t, fixturesDir, "fixtures", "StoreFuncs", "Store", func(g *Generator) {
+		g.ThreadSafe = true
+		g.Spy = true
+		g.Imports = append(g.Imports, Import{Name: "sync", Path: "sync"})
+	})
+
+	// concurrent calls both record themselves and run a function field
+	// mutating state it does not lock itself
+	const race = `package fixtures
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentCalls(t *testing.T) {
+	calls := 0
+	store := &StoreFuncs{get: func(context.Context, string) (Item, error) {
+		calls++
+		return Item{}, nil
+	}}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Get(context.Background(), "key")
+		}()
+	}
+	wg.Wait()
+	if calls != 8 || len(store.GetCalls) != 8 {
+		t.Errorf("calls = %d, recorded %d, want 8", calls, len(store.GetCalls))
+	}
+}
+`
+	out, err := goTest(t, map[string]string{"gen.go": string(src), "race_test.go": race}, "-race")
+	if bytes.Contains(out, []byte("-race requires cgo")) || bytes.Contains(out, []byte("-race is not supported")) {
+		t.Skipf("the race detector is not available: %s", out)
+	}
+	if err != nil {
+		t.Fatalf("go test -race: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateEmbeddedModes(t *testing.T) {
+	parsers := map[string]func() (Interface, error){
+		"types": func() (Interface, error) {
+			return parseInterfaceWithTypes(fixturesDir, "", "NamedReader", "NamedReader", false)
+		},
+		"ast": func() (Interface, error) {
+			return parseInterfaceWithAST(fixturesDir, "", "NamedReader", "NamedReader", false)
+		},
+	}
+	tests := []struct {
+		mode     string
+		contains string
+	}{
+		{EmbeddedFlatten, "func (namedreader_impl _NamedReader_) Read(p []byte) (n int, err error) {"},
+		{EmbeddedSkip, "type _NamedReader_ struct {\n\tname func() string\n}"},
+		{EmbeddedDelegate, "\tio.Reader\n"},
+	}
+	for via, parse := range parsers {
+		iface, err := parse()
+		if err != nil {
+			t.Fatalf("%s: %v", via, err)
+		}
+		for _, tt := range tests {
+			t.Run(via+"/"+tt.mode, func(t *testing.T) {
+				src := render(t, iface, tt.mode, "fixtures", "NamedReaderFuncs", "NamedReader")
+				if !bytes.Contains(src, []byte(tt.contains)) {
+					t.Errorf("generated code does not contain %q:\n%s", tt.contains, src)
+				}
+				if tt.mode == EmbeddedSkip && bytes.Contains(src, []byte("Read(")) {
+					t.Errorf("generated code implements the skipped Read:\n%s", src)
+				}
+				if tt.mode != EmbeddedSkip {
+					vet(t, map[string][]byte{"gen.go": src})
+				}
+			})
+		}
+	}
+}
+
+func TestGeneratePointerParams(t *testing.T) {
+	// both resolution paths import the package of a pointer's base type
+	parsers := map[string]func() (Interface, error){
+		"types": func() (Interface, error) {
+			return parseInterfaceWithTypes(fixturesDir, "", "Buffered", "Buffered", false)
+		},
+		"ast": func() (Interface, error) {
+			return parseInterfaceWithAST(fixturesDir, "", "Buffered", "Buffered", false)
+		},
+	}
+	for via, parse := range parsers {
+		t.Run(via, func(t *testing.T) {
+			iface, err := parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := render(t, iface, EmbeddedFlatten, "fixtures", "BufferedFuncs", "Buffered")
+			checkGolden(t, "pointer", got)
+			vet(t, map[string][]byte{"gen.go": got})
+		})
+	}
+}
+
+func TestGenerateTypeArgImports(t *testing.T) {
+	// type arguments of parameters import their packages
+	got := generate(t, fixturesDir, "fixtures", "SchedulerFuncs", "Scheduler")
+	checkGolden(t, "type_args", got)
+	vet(t, map[string][]byte{"gen.go": got})
+
+	// and so do the type arguments instantiating the interface
+	iface, err := ParseInterface(fixturesDir, "Container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface, err = iface.Instantiate(fixturesDir, []string{"string", "time.Duration"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = render(t, iface, EmbeddedFlatten, "fixtures", "DurationsFuncs", "Container", func(g *Generator) {
+		g.InterfaceType = "Container[string, time.Duration]"
+	})
+	if !bytes.Contains(got, []byte("\t\"time\"\n")) {
+		t.Errorf("Container[string, time.Duration] does not import time:\n%s", got)
+	}
+	vet(t, map[string][]byte{"gen.go": got})
+}
+
+func TestGenerateVerify(t *testing.T) {
+	src := generate(t, fixturesDir, "fixtures", "StoreFuncs", "Store", func(g *Generator) {
+		g.OutputFile = "gen_test.go"
+		g.Verify = true
+		g.Spy = true
+		importing("testing")(g)
+	})
+
+	// Get is called, Put is implemented but never called
+	const verify = `package fixtures
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestVerify(t *testing.T) {
+	store := &StoreFuncs{
+		get: func(context.Context, string) (Item, error) { return Item{}, nil },
+		put: func(context.Context, string, Item) error { return nil },
+	}
+	store.Get(context.Background(), "key")
+
+	var r recorder
+	store.Verify(&r)
+	if want := []string{"StoreFuncs: method Put was never called"}; !slices.Equal(r.errors, want) {
+		t.Errorf("Verify reported %q, want %q", r.errors, want)
+	}
+}
+`
+	if out, err := goTest(t, map[string]string{"gen_test.go": string(src), "verify_test.go": verify}); err != nil {
+		t.Fatalf("go test: %v\n%s\n%s", err, out, src)
+	}
+}
+
+func TestGenerateUnimplemented(t *testing.T) {
+	src := generate(t, fixturesDir, "fixtures", "StoreFuncs", "Store", func(g *Generator) {
+		g.Unimplemented = true
+	})
+
+	// the embedder overrides Get only
+	const embed = `package fixtures
+
+import (
+	"context"
+	"testing"
+)
+
+type getter struct {
+	UnimplementedStoreFuncs
+}
+
+func (getter) Get(context.Context, string) (Item, error) {
+	return Item{Name: "got"}, nil
+}
+
+var _ Store = getter{}
+
+func TestUnimplemented(t *testing.T) {
+	var store Store = getter{}
+	if item, err := store.Get(context.Background(), "key"); item.Name != "got" || err != nil {
+		t.Errorf("Get = %v, %v, want the override", item, err)
+	}
+
+	defer func() {
+		if r := recover(); r != "unimplemented: Put" {
+			t.Errorf("Put panicked with %v, want unimplemented: Put", r)
+		}
+	}()
+	store.Put(context.Background(), "key", Item{})
+	t.Error("Put did not panic")
+}
+`
+	if out, err := goTest(t, map[string]string{"gen.go": string(src), "embed_test.go": embed}); err != nil {
+		t.Fatalf("go test: %v\n%s\n%s", err, out, src)
+	}
+}
+
+func TestGenerateFallback(t *testing.T) {
+	src := generate(t, fixturesDir, "fixtures", "StoreFuncs", "Store", func(g *Generator) {
+		g.Fallback = true
+	})
+
+	// Get is overridden, Put falls through to the embedded base
+	const fallback = `package fixtures
+
+import (
+	"context"
+	"testing"
+)
+
+type base struct {
+	puts []string
+}
+
+func (b *base) Get(context.Context, string) (Item, error) {
+	return Item{Name: "base"}, nil
+}
+
+func (b *base) Put(_ context.Context, key string, _ Item) error {
+	b.puts = append(b.puts, key)
+	return nil
+}
+
+func TestFallback(t *testing.T) {
+	b := &base{}
+	store := StoreFuncs{Store: b, get: func(context.Context, string) (Item, error) {
+		return Item{Name: "override"}, nil
+	}}
+
+	if item, _ := store.Get(context.Background(), "key"); item.Name != "override" {
+		t.Errorf("Get = %v, want the override", item)
+	}
+	if err := store.Put(context.Background(), "key", Item{}); err != nil || len(b.puts) != 1 {
+		t.Errorf("Put = %v, base recorded %q, want the call to fall through", err, b.puts)
+	}
+}
+`
+	if out, err := goTest(t, map[string]string{"gen.go": string(src), "fallback_test.go": fallback}); err != nil {
+		t.Fatalf("go test: %v\n%s\n%s", err, out, src)
+	}
+}
+
+func TestGenerateWrapErrors(t *testing.T) {
+	logger := generate(t, fixturesDir, "fixtures", "StoreFuncs", "Store", decorating)
+	timer := generate(t, fixturesDir, "fixtures", "StoreFuncs", "Store", timing)
+
+	const wrap = `package fixtures
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+var errBoom = errors.New("boom")
+
+type failing struct{}
+
+func (failing) Get(context.Context, string) (Item, error) { return Item{}, nil }
+func (failing) Put(context.Context, string, Item) error   { return errBoom }
+
+func TestWrapErrors(t *testing.T) {
+	stores := map[string]Store{
+		"logger": NewStoreFuncsLogger(failing{}, log.New(io.Discard, "", 0)),
+		"timing": NewStoreFuncsTiming(failing{}, func(string, time.Duration) {}),
+	}
+	for name, store := range stores {
+		err := store.Put(context.Background(), "key", Item{})
+		if err == nil || err.Error() != "StoreFuncs.Put: boom" || !errors.Is(err, errBoom) {
+			t.Errorf("%s: Put = %v, want StoreFuncs.Put: boom wrapping errBoom", name, err)
+		}
+		if _, err := store.Get(context.Background(), "key"); err != nil {
+			t.Errorf("%s: Get = %v, want nil", name, err)
+		}
+	}
+}
+`
+	files := map[string]string{"logger.go": string(logger), "timing.go": string(timer), "wrap_test.go": wrap}
+	if out, err := goTest(t, files); err != nil {
+		t.Fatalf("go test: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateFuncAdapterMethods(t *testing.T) {
+	iface, err := ParseInterface(fixturesDir, "Store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := Generator{
+		StructName:    "StoreFuncs",
+		InterfaceName: "Store",
+		PackageName:   "fixtures",
+		InterfaceType: "Store",
+		Methods:       iface.Methods,
+		FuncAdapter:   true,
+	}
+	_, err = g.Render()
+	if want := "a func adapter needs an interface with exactly one method, Store has 2"; err == nil || err.Error() != want {
+		t.Errorf("Render = %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,1555 @@
+package duckimpl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// DetectPackageName returns the package declared by the Go files in dir,
+// preferring the non-test package when an external _test package is present.
+// It fails if dir holds no Go files, as in a new package, since nothing tells
+// what the package is called.
+func DetectPackageName(dir string) (string, error) {
+	// Parse the directory to get the package name
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, nil, parser.PackageClauseOnly)
+	if err != nil && !os.IsNotExist(err) {
+		return "", errorf(ErrInvalidPackage, "could not parse directory %s: %v", dir, err)
+	}
+
+	if names := sortedPackageNames(pkgs); len(names) > 0 {
+		debugLog("Detected package %q for directory %s\n", names[0], dir)
+		return names[0], nil
+	}
+
+	return "", errorf(ErrInvalidPackage, "no Go files in %s to take the package name from", dir)
+}
+
+// DeclaredNames returns the package-level identifiers declared by package
+// pkgName in dir, leaving out the file exclude, such as a previously
+// generated output file
+func DeclaredNames(dir, pkgName, exclude string) (map[string]bool, error) {
+	fset := token.NewFileSet()
+	filter := func(fi fs.FileInfo) bool { return fi.Name() != filepath.Base(exclude) }
+	pkgs, err := parser.ParseDir(fset, dir, filter, parser.SkipObjectResolution)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errorf(ErrInvalidPackage, "could not parse directory %s: %v", dir, err)
+	}
+
+	names := make(map[string]bool)
+	pkg, ok := pkgs[pkgName]
+	if !ok {
+		return names, nil
+	}
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					names[decl.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						names[spec.Name.Name] = true
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							names[name.Name] = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return names, nil
+}
+
+// sortedPackageNames returns the names of pkgs with non-test packages first,
+// each group sorted alphabetically, so callers can deterministically take
+// the first one
+func sortedPackageNames(pkgs map[string]*ast.Package) []string {
+	names := make([]string, 0, len(pkgs))
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		iTest, jTest := strings.HasSuffix(names[i], "_test"), strings.HasSuffix(names[j], "_test")
+		if iTest != jTest {
+			return jTest
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func SplitRight(s, sep string) []string {
+	idx := strings.LastIndex(s, sep)
+	if idx == -1 {
+		return []string{s} // separator not found
+	}
+	return []string{s[:idx], s[idx+len(sep):]}
+}
+
+// ParseInterface resolves interfaceName, either a local interface or one
+// qualified as path/to/pkg.Interface, relative to the package in dir.
+func ParseInterface(dir, interfaceName string) (Interface, error) {
+	return parseInterface(dir, interfaceName, false)
+}
+
+// ParseInterfaceFromFile parses the interface called interfaceName from the
+// single Go source file filename, which need not belong to a buildable
+// package or module. Interfaces it embeds must be declared in the same file
+// or imported by it.
+func ParseInterfaceFromFile(filename, interfaceName string) (Interface, error) {
+	debugLog("Parsing %s from file %s\n", interfaceName, filename)
+	trace("file", map[string]interface{}{"file": filename, "interface": interfaceName})
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		return Interface{}, fmt.Errorf("could not parse file: %v", err)
+	}
+
+	files := map[string]*ast.File{filename: file}
+	interfaceSpec, _ := findTypeSpec(files, interfaceName)
+	if interfaceSpec == nil {
+		return Interface{}, errorf(ErrInterfaceNotFound, "interface %s not found in %s", interfaceName, filename)
+	}
+	return interfaceFromSpec(filepath.Dir(filename), "", file.Name.Name, interfaceName, interfaceName, interfaceSpec, file, files, fset, nil)
+}
+
+// ParseTestInterface is like ParseInterface, but a local interface is also
+// searched for in the _test.go files in dir, including those of an external
+// _test package. Interface.Package reports the package it was found in.
+func ParseTestInterface(dir, interfaceName string) (Interface, error) {
+	return parseInterface(dir, interfaceName, true)
+}
+
+func parseInterface(dir, interfaceName string, tests bool) (Interface, error) {
+	// Handle potentially qualified interface name (package.Interface)
+	var pkgPath, intName string
+	parts := SplitRight(interfaceName, ".")
+	if len(parts) > 1 {
+		pkgPath = parts[0]
+		intName = parts[len(parts)-1] // Use the last part as the interface name
+	} else {
+		intName = interfaceName
+	}
+
+	debugLog("Looking for interface: package=%s, name=%s\n", pkgPath, intName)
+	trace("lookup", map[string]interface{}{"dir": dir, "package": pkgPath, "interface": intName, "tests": tests})
+
+	// First, try using the go/packages approach (preferred)
+	iface, err := parseInterfaceWithTypes(dir, pkgPath, intName, interfaceName, tests)
+	if err == nil {
+		return iface, nil
+	}
+
+	debugLog("go/packages approach failed: %v\n", err)
+	infoLog("Falling back to the AST-based approach for %s\n", interfaceName)
+	trace("fallback", map[string]interface{}{"reason": err.Error()})
+
+	// Fall back to the AST-based approach
+	return parseInterfaceWithAST(dir, pkgPath, intName, interfaceName, tests)
+}
+
+// parseInterfaceWithTypes uses the go/packages and go/types packages to load and analyze interfaces
+func parseInterfaceWithTypes(dir, pkgPath, intName, fullInterfaceName string, tests bool) (Interface, error) {
+	var importPath string
+
+	if pkgPath == "" {
+		// For interfaces in the current package, we need to determine the import path
+		cmd := exec.Command("go", "list", "-f", "{{.ImportPath}}")
+		cmd.Dir = dir // Set working directory for the command
+		output, err := cmd.Output()
+		if err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return Interface{}, errorf(ErrInvalidPackage, "failed to determine current package import path: %s", bytes.TrimSpace(exitErr.Stderr))
+			}
+			return Interface{}, errorf(ErrInvalidPackage, "failed to determine current package import path: %v", err)
+		}
+		importPath = strings.TrimSpace(string(output))
+	} else {
+		// Extract the actual import path from the package path
+		// For paths like "github.com/user/repo/path/to/module.Interface",
+		// we need to determine the module path (could be repo or repo/path/to/module)
+		importPath = pkgPath
+
+		// Try to find the base module path by iteratively trying shorter paths
+		components := strings.Split(pkgPath, "/")
+		for i := len(components); i > 0; i-- {
+			partialPath := strings.Join(components[:i], "/")
+			if isValidModule(dir, partialPath) {
+				importPath = partialPath
+				debugLog("Found valid module: %s\n", importPath)
+				trace("module", map[string]interface{}{"importPath": importPath})
+				break
+			}
+		}
+	}
+
+	// The go command run by packages.Load reads GOFLAGS, like -mod=vendor,
+	// from the environment, as do the go list invocations above
+	debugLog("Loading package: %s (GOFLAGS=%q)\n", importPath, os.Getenv("GOFLAGS"))
+
+	// Configure the packages.Load. The types of the imported packages, read
+	// from their export data, are enough to find interfaces declared in the
+	// dependencies of the loaded package.
+	cfg := &packages.Config{
+		Mode:  packages.NeedName | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedSyntax | packages.NeedImports,
+		Dir:   dir, // Set the working directory
+		Tests: tests,
+	}
+
+	pkgs, err := loadPackages(cfg, importPath)
+	if err != nil {
+		trace("load", map[string]interface{}{"importPath": importPath, "dir": dir, "goflags": os.Getenv("GOFLAGS"), "error": err.Error()})
+		return Interface{}, errorf(ErrInvalidPackage, "failed to load package %s: %v", importPath, err)
+	}
+	trace("load", map[string]interface{}{"importPath": importPath, "dir": dir, "packages": len(pkgs)})
+
+	if len(pkgs) == 0 {
+		return Interface{}, errorf(ErrInvalidPackage, "no packages found for %s", importPath)
+	}
+
+	// Check for load errors
+	var errs []string
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, err := range pkg.Errors {
+			errs = append(errs, err.Error())
+		}
+	})
+
+	if len(errs) > 0 {
+		trace("load errors", map[string]interface{}{"importPath": importPath, "errors": errs})
+		return Interface{}, errorf(ErrInvalidPackage, "errors loading packages: %s", strings.Join(errs, "; "))
+	}
+
+	// With tests, the package is loaded along with its test variants; use the
+	// first one declaring the interface
+	pkg := pkgs[0]
+	if tests {
+		for _, candidate := range pkgs {
+			if candidate.Types != nil && candidate.Types.Scope().Lookup(intName) != nil {
+				pkg = candidate
+				break
+			}
+		}
+	}
+
+	return interfaceFromPackage(pkg, pkgPath == "", intName, fullInterfaceName, importPath)
+}
+
+// FindInterface searches the packages matching pattern, like ./... or an
+// import path, loaded relative to dir, for the interface intName, and returns
+// its qualified name, like example.com/app/store.Store, for ParseInterface.
+// It fails unless exactly one of the packages declares such an interface.
+func FindInterface(dir, pattern, intName string) (string, error) {
+	debugLog("Searching %s for interface %s\n", pattern, intName)
+	trace("find", map[string]interface{}{"dir": dir, "pattern": pattern, "interface": intName})
+
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedTypes,
+		Dir:  dir,
+	}
+	pkgs, err := loadPackages(cfg, pattern)
+	if err != nil {
+		return "", errorf(ErrInvalidPackage, "failed to load packages %s: %v", pattern, err)
+	}
+	if len(pkgs) == 0 {
+		return "", errorf(ErrInvalidPackage, "no packages found for %s", pattern)
+	}
+
+	// a package that fails to load may well be the one declaring the interface
+	var errs []string
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, err := range pkg.Errors {
+			errs = append(errs, err.Error())
+		}
+	})
+	if len(errs) > 0 {
+		trace("load errors", map[string]interface{}{"pattern": pattern, "errors": errs})
+		return "", errorf(ErrInvalidPackage, "errors loading packages: %s", strings.Join(errs, "; "))
+	}
+
+	var candidates []string
+	for _, pkg := range pkgs {
+		if pkg.Types == nil {
+			continue
+		}
+		obj, ok := pkg.Types.Scope().Lookup(intName).(*types.TypeName)
+		if ok && types.IsInterface(obj.Type()) {
+			candidates = append(candidates, pkg.PkgPath+"."+intName)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", errorf(ErrInterfaceNotFound, "interface %s not found in packages matching %s", intName, pattern)
+	case 1:
+		infoLog("Found %s\n", candidates[0])
+		return candidates[0], nil
+	default:
+		sort.Strings(candidates)
+		return "", fmt.Errorf("interface %s is declared by several packages matching %s, qualify it as one of: %s", intName, pattern, strings.Join(candidates, ", "))
+	}
+}
+
+// loadKey identifies a packages.Load call by its pattern and the parts of the
+// configuration affecting its result
+type loadKey struct {
+	dir     string
+	pattern string
+	mode    packages.LoadMode
+	tests   bool
+}
+
+// loaded caches the packages loaded by this process, since resolving several
+// interfaces, as -methodsFrom does, loads overlapping packages repeatedly
+var (
+	loadedMu sync.Mutex
+	loaded   = make(map[loadKey][]*packages.Package)
+)
+
+// loadPackages is packages.Load, loading each pattern at most once per process.
+// Failed loads are not cached. Dependencies are type-checked from source when
+// their export data cannot be read.
+func loadPackages(cfg *packages.Config, pattern string) ([]*packages.Package, error) {
+	if cfg.Mode&packages.NeedTypes != 0 && !canReadExportData(cfg.Dir) {
+		cfg.Mode |= packages.NeedSyntax | packages.NeedImports | packages.NeedDeps
+	}
+	key := loadKey{dir: cfg.Dir, pattern: pattern, mode: cfg.Mode, tests: cfg.Tests}
+
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+
+	if pkgs, ok := loaded[key]; ok {
+		debugLog("Package cache hit: %s\n", pattern)
+		trace("load cache hit", map[string]interface{}{"importPath": pattern, "dir": cfg.Dir})
+		return pkgs, nil
+	}
+
+	start := time.Now()
+	pkgs, err := packages.Load(cfg, pattern)
+	if err != nil {
+		return nil, err
+	}
+	debugLog("Loaded %s in %v\n", pattern, time.Since(start))
+
+	loaded[key] = pkgs
+	return pkgs, nil
+}
+
+// interfaceFromPackage looks up the interface intName in pkg, or in one of the
+// packages it imports, and extracts its methods. local reports whether pkg is
+// the package the code is generated into.
+func interfaceFromPackage(pkg *packages.Package, local bool, intName, fullInterfaceName, importPath string) (Interface, error) {
+	debugLog("Package loaded: %s\n", pkg.Name)
+
+	// Types declared in the current package are left unqualified
+	var localPkg *types.Package
+	if local {
+		localPkg = pkg.Types
+	}
+
+	// Look up the interface type
+	obj := pkg.Types.Scope().Lookup(intName)
+	if obj == nil {
+		// If not found directly, try to search in imported packages, whose
+		// types are known without loading them as packages of their own
+		for _, imported := range pkg.Types.Imports() {
+			obj = imported.Scope().Lookup(intName)
+			if obj != nil {
+				// Use the package where the interface was found
+				pkg = &packages.Package{
+					Name:    imported.Name(),
+					PkgPath: imported.Path(),
+					Types:   imported,
+				}
+				break
+			}
+		}
+	}
+
+	if obj == nil {
+		return Interface{}, errorf(ErrInterfaceNotFound, "interface %s not found in package %s", intName, importPath)
+	}
+
+	// Verify it's an interface type, resolving aliases to the type they denote
+	if _, ok := obj.(*types.TypeName); !ok {
+		return Interface{}, errorf(ErrNotInterface, "%s is a %s, not an interface type", intName, describeObject(obj))
+	}
+
+	iface, ok := types.Unalias(obj.Type()).Underlying().(*types.Interface)
+	if !ok {
+		return Interface{}, errorf(ErrNotInterface, "%s is a %s, not an interface type", intName, describeObject(obj))
+	}
+	if !iface.IsMethodSet() {
+		return Interface{}, fmt.Errorf("cannot implement a constraint interface: %s has a type set", intName)
+	}
+
+	// Only named interfaces can have type parameters, and an alias of an
+	// instantiation like Getter[string] has already bound them
+	named, _ := types.Unalias(obj.Type()).(*types.Named)
+	if named != nil && named.TypeArgs().Len() > 0 {
+		named = nil
+	}
+
+	infoLog("Found interface %s in package %s\n", intName, pkg.Name)
+	trace("found", map[string]interface{}{"interface": intName, "package": pkg.PkgPath, "via": "types"})
+
+	// Record which embedded interface each promoted method comes from
+	explicit := make(map[string]bool)
+	for i := 0; i < iface.NumExplicitMethods(); i++ {
+		explicit[iface.ExplicitMethod(i).Name()] = true
+	}
+
+	// A single namer qualifies all types of the interface so that packages
+	// sharing a name are given distinct aliases
+	namer := newImportNamer(localPkg)
+
+	type embeddedSource struct {
+		name    string
+		imports map[string]string
+	}
+	embeddedFrom := make(map[string]embeddedSource)
+	for i := 0; i < iface.NumEmbeddeds(); i++ {
+		embType := iface.EmbeddedType(i)
+		embIface, ok := embType.Underlying().(*types.Interface)
+		if !ok {
+			continue
+		}
+
+		source := embeddedSource{imports: make(map[string]string)}
+		source.name = types.TypeString(embType, namer.qualifier(source.imports))
+
+		for j := 0; j < embIface.NumMethods(); j++ {
+			name := embIface.Method(j).Name()
+			if _, ok := embeddedFrom[name]; !ok && !explicit[name] {
+				embeddedFrom[name] = source
+			}
+		}
+	}
+
+	// Doc comments are only available when the syntax of the host package was loaded
+	docs := methodDocs(pkg.Syntax)
+
+	// Extract methods from the interface
+	var methods []Method
+	for i := 0; i < iface.NumMethods(); i++ {
+		meth := iface.Method(i)
+		sig := meth.Type().(*types.Signature)
+
+		method := Method{
+			MethodName:      meth.Name(),
+			Variadic:        sig.Variadic(),
+			Doc:             docs[meth.Pos()],
+			Embedded:        embeddedFrom[meth.Name()].name,
+			EmbeddedImports: embeddedFrom[meth.Name()].imports,
+		}
+
+		// collect imports from interface's methods: every package the qualifier
+		// is asked about is referenced by a parameter or result type
+		imports := make(map[string]string)
+		qualifier := namer.qualifier(imports)
+		// Process parameters
+		for j := range sig.Params().Len() {
+			param := sig.Params().At(j)
+			paramTypeStr := types.TypeString(param.Type(), qualifier)
+
+			// Handle variadic parameters
+			if sig.Variadic() && j == sig.Params().Len()-1 {
+				slice, ok := param.Type().(*types.Slice)
+				if ok {
+					elemTypeStr := types.TypeString(slice.Elem(), qualifier)
+					paramTypeStr = "..." + elemTypeStr
+				}
+			}
+
+			method.Params = append(method.Params, Param{Name: param.Name(), Type: paramTypeStr})
+		}
+		method.Params = nameParams(method.Params)
+
+		// Process return values
+		for j := range sig.Results().Len() {
+			result := sig.Results().At(j)
+			resultTypeStr := types.TypeString(result.Type(), qualifier)
+
+			// If the result has no name, just use the type
+			method.Returns = append(method.Returns, Param{Name: result.Name(), Type: resultTypeStr})
+		}
+		// Parameters alone may reference packages, as in Handle(w http.ResponseWriter)
+		method.Imports = imports
+
+		methods = append(methods, method)
+	}
+
+	// Type parameters of a generic interface, qualified like method types.
+	// Predeclared constraints like comparable need no import.
+	var typeParams []Param
+	typeParamImports := make(map[string]string)
+	for i := 0; named != nil && i < named.TypeParams().Len(); i++ {
+		tparam := named.TypeParams().At(i)
+		typeParams = append(typeParams, Param{
+			Name: tparam.Obj().Name(),
+			Type: types.TypeString(tparam.Constraint(), namer.qualifier(typeParamImports)),
+		})
+	}
+
+	return Interface{
+		Name:             fullInterfaceName,
+		Package:          pkg.Name,
+		PkgPath:          canonicalImportPath(pkg.PkgPath),
+		TypeParams:       typeParams,
+		Methods:          dedupeMethods(methods),
+		TypeParamImports: typeParamImports,
+		Via:              "types",
+	}, nil
+}
+
+// dedupeMethods drops methods whose name was seen before, as contributed by
+// embedded interfaces sharing a method like io.ReadCloser and io.WriteCloser.
+// The first occurrence is kept, unless a later one is declared explicitly.
+// Methods sharing a name with a different signature are all kept.
+func dedupeMethods(methods []Method) []Method {
+	index := make(map[string]int, len(methods))
+	deduped := make([]Method, 0, len(methods))
+	for _, method := range methods {
+		i, ok := index[method.MethodName]
+		if !ok {
+			index[method.MethodName] = len(deduped)
+			deduped = append(deduped, method)
+			continue
+		}
+
+		if first := deduped[i]; signature(first) != signature(method) {
+			// Keep both, so that generating the implementation reports the conflict
+			debugLog("Method %s has differing signatures %s and %s\n",
+				method.MethodName, signature(first), signature(method))
+			deduped = append(deduped, method)
+			continue
+		}
+		if method.Embedded == "" {
+			deduped[i].Embedded = ""
+			deduped[i].EmbeddedImports = nil
+		}
+	}
+	return deduped
+}
+
+// signature renders the parameter and result types of method, without names
+func signature(method Method) string {
+	typeList := func(params []Param) string {
+		typs := make([]string, len(params))
+		for i, param := range params {
+			typs[i] = param.Type
+		}
+		return "(" + strings.Join(typs, ", ") + ")"
+	}
+	return typeList(method.Params) + " " + typeList(method.Returns)
+}
+
+// describeObject names the kind of obj for error messages, like "struct type"
+// or "function"
+func describeObject(obj types.Object) string {
+	switch obj := obj.(type) {
+	case *types.Func:
+		return "function"
+	case *types.Var:
+		return "variable"
+	case *types.Const:
+		return "constant"
+	case *types.PkgName:
+		return "package"
+	case *types.TypeName:
+		kind := "type"
+		if obj.IsAlias() {
+			kind = "type alias"
+		}
+
+		switch types.Unalias(obj.Type()).Underlying().(type) {
+		case *types.Struct:
+			return "struct " + kind
+		case *types.Signature:
+			return "func " + kind
+		case *types.Map:
+			return "map " + kind
+		case *types.Slice, *types.Array:
+			return "slice or array " + kind
+		case *types.Pointer:
+			return "pointer " + kind
+		case *types.Chan:
+			return "channel " + kind
+		case *types.Basic:
+			return fmt.Sprintf("%s (%s)", kind, obj.Type().Underlying())
+		}
+		return kind
+	}
+	return "non-type object"
+}
+
+// importNamer assigns every package referenced by the generated code the
+// name used to qualify its types, so the import block and the type strings
+// never diverge. Packages sharing a name get numbered aliases.
+type importNamer struct {
+	local *types.Package    // types from this package are printed unqualified
+	names map[string]string // import path -> name
+	taken map[string]string // name -> import path
+}
+
+func newImportNamer(local *types.Package) *importNamer {
+	return &importNamer{
+		local: local,
+		names: make(map[string]string),
+		taken: make(map[string]string),
+	}
+}
+
+// name returns the name qualifying the types of p, assigning one on first use
+func (n *importNamer) name(p *types.Package) string {
+	if name, ok := n.names[p.Path()]; ok {
+		return name
+	}
+
+	name := p.Name()
+	for i := 2; n.taken[name] != ""; i++ {
+		name = fmt.Sprintf("%s%d", p.Name(), i)
+	}
+	n.names[p.Path()] = name
+	n.taken[name] = p.Path()
+	return name
+}
+
+// qualifier returns a types.Qualifier that records every package it
+// qualifies in imports
+func (n *importNamer) qualifier(imports map[string]string) types.Qualifier {
+	return func(p *types.Package) string {
+		if p == n.local {
+			return ""
+		}
+		name := n.name(p)
+		imports[canonicalImportPath(p.Path())] = name
+		return name
+	}
+}
+
+// methodDocs collects the doc comments of all interface methods declared in
+// files, keyed by the position of the method name
+func methodDocs(files []*ast.File) map[token.Pos]string {
+	docs := make(map[token.Pos]string)
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			iface, ok := n.(*ast.InterfaceType)
+			if !ok || iface.Methods == nil {
+				return true
+			}
+
+			for _, field := range iface.Methods.List {
+				if field.Doc == nil {
+					continue
+				}
+				for _, name := range field.Names {
+					docs[name.Pos()] = field.Doc.Text()
+				}
+			}
+			return true
+		})
+	}
+	return docs
+}
+
+// isValidModule checks if the given import path is a valid Go module,
+// resolved from the module containing dir
+func isValidModule(dir, importPath string) bool {
+	cmd := exec.Command("go", "list", "-f", "{{.Dir}}", importPath)
+	cmd.Dir = dir
+	if err := cmd.Run(); err != nil {
+		return false
+	}
+	return true
+}
+
+func findModulePath(dir, importPath string) (string, error) {
+	cmd := exec.Command("go", "list", "-f", "{{.Dir}}", importPath)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", errorf(ErrInvalidPackage, "go list failed: %s", exitErr.Stderr)
+		}
+		return "", errorf(ErrInvalidPackage, "failed to execute go list: %v", err)
+	}
+	debugLog("Found module path: %s\n", string(output))
+	return strings.TrimSpace(string(output)), nil
+}
+
+// parseInterfaceWithAST is the original AST-based approach as a fallback.
+// The _test.go files in dir are only searched when tests is set.
+func parseInterfaceWithAST(dir, pkgPath, intName, fullInterfaceName string, tests bool) (Interface, error) {
+	fset := token.NewFileSet()
+	trace("ast", map[string]interface{}{"dir": dir, "package": pkgPath, "interface": intName})
+
+	// Parse the package
+	var filter func(fs.FileInfo) bool
+	if !tests {
+		filter = func(fi fs.FileInfo) bool { return !strings.HasSuffix(fi.Name(), "_test.go") }
+	}
+	pkgs, err := parser.ParseDir(fset, dir, filter, parser.ParseComments)
+	if err != nil {
+		return Interface{}, errorf(ErrInvalidPackage, "could not parse directory: %v", err)
+	}
+
+	var interfaceSpec *ast.TypeSpec
+	var interfaceFile *ast.File
+	var pkgFiles map[string]*ast.File
+	var hostPkgName string
+	var stdPkgs map[string]*ast.Package
+
+	if pkgPath != "" {
+		// Determine the full import path for the package
+		importPath := pkgPath
+
+		debugLog("Attempting to load package: %s\n", importPath)
+
+		// First try standard library
+		goRoot := runtime.GOROOT()
+		stdLibPath := filepath.Join(goRoot, "src", filepath.FromSlash(importPath))
+
+		debugLog("Searching in standard library path: %s\n", stdLibPath)
+		trace("search", map[string]interface{}{"kind": "stdlib", "path": stdLibPath})
+
+		if _, err := os.Stat(stdLibPath); err == nil {
+			// Parse the standard library package
+			stdPkgs, err = parser.ParseDir(fset, stdLibPath, nil, parser.ParseComments)
+			if err == nil {
+				for stdPkgName, stdPkg := range stdPkgs {
+					debugLog("Found standard package: %s\n", stdPkgName)
+					hostPkgName = stdPkgName
+
+					// Look for the interface in the standard package
+					if interfaceSpec, interfaceFile = findTypeSpec(stdPkg.Files, intName); interfaceSpec != nil {
+						debugLog("Found type %s in standard library\n", intName)
+						pkgFiles = stdPkg.Files
+						break
+					}
+				}
+			}
+		}
+
+		// If not found in standard library, try to find module root first
+		if interfaceSpec == nil {
+			// Try to find the base module path by iteratively trying shorter paths
+			debugLog("let's try to find the module path by iteratively trying shorter paths\n")
+			components := strings.Split(pkgPath, "/")
+			var modulePath string
+
+			for i := len(components); i > 0; i-- {
+				partialPath := strings.Join(components[:i], "/")
+				path, err := findModulePath(dir, partialPath)
+				debugLog("path: %s, err: %v\n", path, err)
+				if err != nil {
+					trace("module lookup", map[string]interface{}{"importPath": partialPath, "error": err.Error()})
+				} else {
+					trace("module lookup", map[string]interface{}{"importPath": partialPath, "path": path})
+				}
+				if err == nil && path != "" {
+					modulePath = path
+					// If we found a valid module but need to access a subpackage
+					if i < len(components) {
+						modulePath = filepath.Join(modulePath, strings.Join(components[i:], "/"))
+					}
+					debugLog("Found module root: %s, full path: %s\n", partialPath, modulePath)
+					break
+				}
+			}
+
+			if modulePath != "" {
+				debugLog("Found module path: %s\n", modulePath)
+
+				// Parse the module
+				modPkgs, err := parser.ParseDir(fset, modulePath, nil, parser.ParseComments)
+				if err == nil {
+					debugLog("Successfully parsed module directory\n")
+
+					for modPkgName, modPkg := range modPkgs {
+						debugLog("Examining package: %s\n", modPkgName)
+						hostPkgName = modPkgName
+
+						if interfaceSpec, interfaceFile = findTypeSpec(modPkg.Files, intName); interfaceSpec != nil {
+							debugLog("Found type %s in module\n", intName)
+							pkgFiles = modPkg.Files
+							break
+						}
+					}
+				} else {
+					debugLog("Error parsing module directory: %v\n", err)
+				}
+			} else {
+				debugLog("Could not find valid module path\n")
+			}
+
+			// Final fallback to the old approach
+			if interfaceSpec == nil {
+				goPath := os.Getenv("GOPATH")
+				if goPath == "" {
+					// Default GOPATH
+					homeDir, _ := os.UserHomeDir()
+					goPath = filepath.Join(homeDir, "go")
+				}
+
+				// For third-party packages
+				possiblePaths := []string{
+					filepath.Join(goPath, "src", filepath.FromSlash(importPath)),
+					filepath.Join(goPath, "pkg", "mod", filepath.FromSlash(importPath)+"@*"), // For Go modules
+					filepath.Join(dir, "vendor", filepath.FromSlash(importPath)),
+				}
+
+				for _, path := range possiblePaths {
+					debugLog("Searching fallback path: %s\n", path)
+					trace("search", map[string]interface{}{"kind": "fallback", "path": path})
+					matches, _ := filepath.Glob(path)
+
+					for _, match := range matches {
+						if stat, err := os.Stat(match); err == nil && stat.IsDir() {
+							debugLog("Found directory: %s\n", match)
+							// Parse the external package
+							extPkgs, err := parser.ParseDir(fset, match, nil, parser.ParseComments)
+							if err != nil {
+								debugLog("Error parsing directory: %v\n", err)
+								continue
+							}
+
+							// Look for the interface in the external package
+							for extPkgName, extPkg := range extPkgs {
+								debugLog("Examining package: %s\n", extPkgName)
+								hostPkgName = extPkgName
+
+								if interfaceSpec, interfaceFile = findTypeSpec(extPkg.Files, intName); interfaceSpec != nil {
+									debugLog("Found type %s in external package\n", intName)
+									pkgFiles = extPkg.Files
+									break
+								}
+							}
+
+							if interfaceSpec != nil {
+								break
+							}
+						}
+					}
+
+					if interfaceSpec != nil {
+						break
+					}
+				}
+			}
+		}
+	} else {
+		// Look for interface in local package
+		for _, name := range sortedPackageNames(pkgs) {
+			pkg := pkgs[name]
+			hostPkgName = pkg.Name
+
+			if interfaceSpec, interfaceFile = findTypeSpec(pkg.Files, intName); interfaceSpec != nil {
+				debugLog("Found type %s in local package\n", intName)
+				pkgFiles = pkg.Files
+				break
+			}
+		}
+	}
+	if interfaceSpec == nil {
+		trace("not found", map[string]interface{}{"interface": intName, "package": pkgPath})
+		return Interface{}, errorf(ErrInterfaceNotFound, "interface %s not found", intName)
+	}
+	trace("found", map[string]interface{}{"interface": intName, "package": hostPkgName, "file": fset.Position(interfaceSpec.Pos()).Filename, "via": "ast"})
+	if pkgPath != "" {
+		// Types of the other package must be referred to through its import
+		qualifyPackage(pkgFiles, hostPkgName, canonicalImportPath(pkgPath))
+	}
+
+	return interfaceFromSpec(dir, pkgPath, hostPkgName, intName, fullInterfaceName, interfaceSpec, interfaceFile, pkgFiles, fset, stdPkgs)
+}
+
+// interfaceFromSpec extracts the interface declared by interfaceSpec in
+// interfaceFile, one of the pkgFiles of package hostPkgName, following
+// aliases and definitions of other interfaces to the interface type itself
+func interfaceFromSpec(dir, pkgPath, hostPkgName, intName, fullInterfaceName string, interfaceSpec *ast.TypeSpec, interfaceFile *ast.File, pkgFiles map[string]*ast.File, fset *token.FileSet, stdPkgs map[string]*ast.Package) (Interface, error) {
+	// Follow aliases like `type myIO = io.ReadWriteCloser`, and definitions
+	// like `type Source io.Reader`, until the interface type itself is reached
+	var interfaceType *ast.InterfaceType
+	followed := make(map[*ast.TypeSpec]bool)
+	for interfaceType == nil {
+		switch t := interfaceSpec.Type.(type) {
+		case *ast.InterfaceType:
+			interfaceType = t
+
+		case *ast.Ident:
+			if followed[interfaceSpec] {
+				return Interface{}, fmt.Errorf("type %s refers to itself", intName)
+			}
+			followed[interfaceSpec] = true
+
+			debugLog("Following %s to %s\n", interfaceSpec.Name.Name, t.Name)
+			trace("alias", map[string]interface{}{"from": interfaceSpec.Name.Name, "to": t.Name})
+			interfaceSpec, interfaceFile = findTypeSpec(pkgFiles, t.Name)
+			if interfaceSpec == nil {
+				return Interface{}, errorf(ErrNotInterface, "%s refers to %s, which is not an interface type declared in package %s", intName, t.Name, hostPkgName)
+			}
+
+		case *ast.SelectorExpr:
+			pkgIdent, ok := t.X.(*ast.Ident)
+			if !ok {
+				return Interface{}, errorf(ErrNotInterface, "%s is not an interface type", intName)
+			}
+			importPath, ok := fileImports(interfaceFile)[pkgIdent.Name]
+			if !ok {
+				return Interface{}, fmt.Errorf("%s refers to %s, but package %s is not imported", intName, formatNode(t), pkgIdent.Name)
+			}
+
+			debugLog("Following %s to %s.%s\n", interfaceSpec.Name.Name, importPath, t.Sel.Name)
+			trace("alias", map[string]interface{}{"from": interfaceSpec.Name.Name, "to": importPath + "." + t.Sel.Name})
+			iface, err := parseInterfaceWithAST(dir, importPath, t.Sel.Name, fullInterfaceName, false)
+			if err != nil {
+				return Interface{}, err
+			}
+
+			// The interface is still named after the alias and its package
+			iface.Package = hostPkgName
+			iface.PkgPath = canonicalImportPath(pkgPath)
+			return iface, nil
+
+		default:
+			return Interface{}, errorf(ErrNotInterface, "%s is not an interface type", intName)
+		}
+	}
+
+	if isConstraintInterface(interfaceType) {
+		return Interface{}, fmt.Errorf("cannot implement a constraint interface: %s has a type set", intName)
+	}
+
+	// Overlapping embedded interfaces contribute their shared methods repeatedly
+	extracted, err := extractMethodsFromInterface(dir, interfaceType, fset, stdPkgs, pkgFiles, fileImports(interfaceFile))
+	if err != nil {
+		return Interface{}, fmt.Errorf("%s: %v", intName, err)
+	}
+	methods := dedupeMethods(extracted)
+	if len(methods) == 0 && len(interfaceType.Methods.List) > 0 {
+		// The interface declares elements, but none of them resolved to methods
+		infoLog("Warning: %s has no methods; its embedded interfaces may not have been resolved\n", fullInterfaceName)
+	}
+
+	var typeParamImports map[string]string
+	if interfaceSpec.TypeParams != nil {
+		typeParamImports = usedImports(interfaceSpec.TypeParams, fileImports(interfaceFile))
+	}
+
+	return Interface{
+		Name:             fullInterfaceName,
+		Package:          hostPkgName,
+		PkgPath:          canonicalImportPath(pkgPath),
+		TypeParams:       extractParams(interfaceSpec.TypeParams),
+		Methods:          methods,
+		TypeParamImports: typeParamImports,
+		Via:              "ast",
+	}, nil
+}
+
+// findTypeSpec returns the declaration of the type called name in files,
+// together with the file declaring it
+func findTypeSpec(files map[string]*ast.File, name string) (*ast.TypeSpec, *ast.File) {
+	for fileName, file := range files {
+		debugLog("Examining file: %s\n", fileName)
+		trace("scan", map[string]interface{}{"file": fileName})
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+
+			for _, spec := range genDecl.Specs {
+				typeSpec, ok := spec.(*ast.TypeSpec)
+				if ok && typeSpec.Name.Name == name {
+					return typeSpec, file
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// extractMethodsFromInterface returns the methods of iface, declared by it
+// or by the interfaces it embeds. Each element of an interface is either a
+// single method, with exactly one name and a function type, or an embedded
+// interface without a name; anything else, which the parser never produces
+// but a hand-built AST might contain, is reported as an error.
+func extractMethodsFromInterface(dir string, iface *ast.InterfaceType, fset *token.FileSet, stdLibPkgs map[string]*ast.Package, pkgFiles map[string]*ast.File, imports map[string]string) ([]Method, error) {
+	methods := make([]Method, 0)
+
+	for _, field := range iface.Methods.List {
+		// If it's a named method
+		if len(field.Names) > 0 {
+			if len(field.Names) > 1 {
+				return nil, fmt.Errorf("methods %s share one declaration, which is only valid for struct fields", formatNames(field.Names))
+			}
+			name := field.Names[0]
+			funcType, ok := field.Type.(*ast.FuncType)
+			if !ok {
+				return nil, fmt.Errorf("method %s has no function signature", name.Name)
+			}
+
+			foo := Method{
+				MethodName: name.Name,
+				Params:     nameParams(extractParams(funcType.Params)),
+				Returns:    extractParams(funcType.Results),
+				Variadic:   isVariadic(funcType),
+				Imports:    usedImports(funcType, imports),
+			}
+			if field.Doc != nil {
+				foo.Doc = field.Doc.Text()
+			}
+			methods = append(methods, foo)
+		} else {
+			// It might be an embedded interface
+			switch fieldType := field.Type.(type) {
+			case *ast.Ident:
+				// Embedded interface declared in the same package
+				embeddedMethods, err := findEmbeddedInterfaceMethods(dir, fieldType.Name, nil, "", fset, stdLibPkgs, pkgFiles)
+				if err != nil {
+					return nil, err
+				}
+				if typeSpec, _ := findTypeSpec(pkgFiles, fieldType.Name); typeSpec == nil && fieldType.Name == "error" {
+					// the builtin error interface, unless the package declares its own
+					embeddedMethods = []Method{{MethodName: "Error", Params: []Param{}, Returns: []Param{{Type: "string"}}}}
+				}
+				methods = append(methods, markEmbedded(embeddedMethods, fieldType.Name, nil)...)
+
+			case *ast.SelectorExpr:
+				// Embedded interface from another package
+				if pkgIdent, ok := fieldType.X.(*ast.Ident); ok {
+					embeddedMethods, err := findEmbeddedInterfaceMethods(dir, fieldType.Sel.Name, pkgIdent, pkgIdent.Name, fset, stdLibPkgs, pkgFiles)
+					if err != nil {
+						return nil, err
+					}
+					if importPath, ok := imports[pkgIdent.Name]; ok && len(embeddedMethods) == 0 {
+						// Locate and parse the imported package like the interface itself,
+						// which also resolves the interfaces it embeds in turn
+						embedded, err := parseInterfaceWithAST(dir, importPath, fieldType.Sel.Name, formatNode(fieldType), false)
+						if err != nil {
+							debugLog("Could not resolve embedded interface %s: %v\n", formatNode(fieldType), err)
+						}
+						embeddedMethods = embedded.Methods
+					}
+					methods = append(methods, markEmbedded(embeddedMethods, formatNode(fieldType), usedImports(fieldType, imports))...)
+				}
+
+			case *ast.IndexExpr, *ast.IndexListExpr:
+				// Instantiating the type parameters of an embedded generic
+				// interface needs go/types
+				return nil, fmt.Errorf("cannot resolve the embedded generic interface %s without go/packages", formatNode(fieldType))
+
+			default:
+				return nil, fmt.Errorf("unsupported interface element %s", formatNode(fieldType))
+			}
+		}
+	}
+
+	return methods, nil
+}
+
+// formatNames joins the names of idents, like "Read, Write"
+func formatNames(idents []*ast.Ident) string {
+	names := make([]string, len(idents))
+	for i, ident := range idents {
+		names[i] = ident.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// isConstraintInterface reports whether iface has type set elements, such as
+// unions, ~T approximations, non-interface types or comparable, which only
+// constraints may have. Embedding any is an ordinary method set.
+func isConstraintInterface(iface *ast.InterfaceType) bool {
+	for _, field := range iface.Methods.List {
+		if len(field.Names) > 0 {
+			continue
+		}
+		switch fieldType := field.Type.(type) {
+		case *ast.BinaryExpr, *ast.UnaryExpr:
+			return true
+		case *ast.Ident:
+			if obj, ok := types.Universe.Lookup(fieldType.Name).(*types.TypeName); ok {
+				embedded, ok := obj.Type().Underlying().(*types.Interface)
+				if !ok || !embedded.IsMethodSet() {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// markEmbedded records the embedded interface the methods were promoted from,
+// together with the imports referencing it, as import path -> name. Nested
+// embeddings are overwritten so the outermost embedded interface wins.
+func markEmbedded(methods []Method, embedded string, imports map[string]string) []Method {
+	for i := range methods {
+		methods[i].Embedded = embedded
+		methods[i].EmbeddedImports = imports
+	}
+	return methods
+}
+
+// errRecursiveInterface reports an interface embedding itself
+var errRecursiveInterface = errors.New("invalid recursive type")
+
+// expandingSpecs holds the type specs whose embedded interfaces are being
+// resolved, to stop at an interface embedding itself, which would otherwise
+// be expanded forever. Every parse has its own specs.
+var expandingSpecs sync.Map
+
+// findEmbeddedInterfaceMethods extracts the methods of an embedded interface,
+// looked up in the standard library package pkgName or, when pkgName is
+// empty, among pkgFiles of the embedding interface's package. Embedded
+// interfaces that cannot be resolved have no methods; only an interface
+// embedding itself, directly or not, is an error.
+func findEmbeddedInterfaceMethods(dir, interfaceName string, pkgIdent *ast.Ident, pkgName string, fset *token.FileSet, stdLibPkgs map[string]*ast.Package, pkgFiles map[string]*ast.File) ([]Method, error) {
+	files := pkgFiles
+	if pkgName != "" {
+		// Look for the embedded interface in the standard library
+		pkg := stdLibPkgs[pkgName]
+		if pkg == nil {
+			return []Method{}, nil
+		}
+		files = pkg.Files
+	}
+
+	typeSpec, file := findTypeSpec(files, interfaceName)
+	if typeSpec == nil {
+		return []Method{}, nil
+	}
+	if _, expanding := expandingSpecs.LoadOrStore(typeSpec, true); expanding {
+		return nil, fmt.Errorf("%w: %s embeds itself", errRecursiveInterface, interfaceName)
+	}
+	defer expandingSpecs.Delete(typeSpec)
+
+	if ifaceType, ok := typeSpec.Type.(*ast.InterfaceType); ok {
+		methods, err := extractMethodsFromInterface(dir, ifaceType, fset, stdLibPkgs, files, fileImports(file))
+		if errors.Is(err, errRecursiveInterface) {
+			return nil, err
+		}
+		if err != nil {
+			debugLog("Could not resolve embedded interface %s: %v\n", interfaceName, err)
+			return []Method{}, nil
+		}
+		return methods, nil
+	}
+
+	// An alias or definition of another interface, like `type RW = io.ReadWriter`
+	iface, err := interfaceFromSpec(dir, "", pkgName, interfaceName, interfaceName, typeSpec, file, files, fset, stdLibPkgs)
+	if errors.Is(err, errRecursiveInterface) {
+		return nil, err
+	}
+	if err != nil {
+		debugLog("Could not resolve embedded interface %s: %v\n", interfaceName, err)
+		return []Method{}, nil
+	}
+	return iface.Methods, nil
+}
+
+// qualifyPackage rewrites the method signatures and type parameter constraints
+// of the interfaces declared in files, which belong to the package pkgName
+// imported as importPath, so that the types declared by the package, and the
+// constants sizing arrays, are qualified by its name, like *http.Request
+// rather than *Request. Each file gains an import of the package itself, so
+// that the qualified types resolve like any other import.
+func qualifyPackage(files map[string]*ast.File, pkgName, importPath string) {
+	declared := make(map[string]bool)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					declared[spec.Name.Name] = true
+				case *ast.ValueSpec:
+					if genDecl.Tok == token.CONST {
+						for _, name := range spec.Names {
+							declared[name.Name] = true
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for _, file := range files {
+		file.Imports = append(file.Imports, &ast.ImportSpec{
+			Name: ast.NewIdent(pkgName),
+			Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(importPath)},
+		})
+		ast.Inspect(file, func(n ast.Node) bool {
+			if spec, ok := n.(*ast.TypeSpec); ok && spec.TypeParams != nil {
+				// constraints, like [T Ordered]
+				for _, field := range spec.TypeParams.List {
+					field.Type = qualifyExpr(field.Type, pkgName, declared)
+				}
+			}
+			if iface, ok := n.(*ast.InterfaceType); ok {
+				for _, field := range iface.Methods.List {
+					// embedded interfaces are resolved by their unqualified name
+					if len(field.Names) > 0 {
+						field.Type = qualifyExpr(field.Type, pkgName, declared)
+					}
+				}
+			}
+			return true
+		})
+	}
+}
+
+// qualifyExpr returns expr with every identifier naming a type or constant in
+// declared qualified by pkgName. Identifiers already qualified by a package are kept.
+func qualifyExpr(expr ast.Expr, pkgName string, declared map[string]bool) ast.Expr {
+	qualifyFields := func(fields *ast.FieldList) {
+		if fields == nil {
+			return
+		}
+		for _, field := range fields.List {
+			field.Type = qualifyExpr(field.Type, pkgName, declared)
+		}
+	}
+
+	switch n := expr.(type) {
+	case *ast.Ident:
+		if declared[n.Name] {
+			return &ast.SelectorExpr{X: ast.NewIdent(pkgName), Sel: n}
+		}
+	case *ast.StarExpr:
+		n.X = qualifyExpr(n.X, pkgName, declared)
+	case *ast.ArrayType:
+		if n.Len != nil {
+			n.Len = qualifyExpr(n.Len, pkgName, declared)
+		}
+		n.Elt = qualifyExpr(n.Elt, pkgName, declared)
+	case *ast.BinaryExpr:
+		// array lengths like Size*2, or unions of inline interfaces
+		n.X = qualifyExpr(n.X, pkgName, declared)
+		n.Y = qualifyExpr(n.Y, pkgName, declared)
+	case *ast.UnaryExpr:
+		n.X = qualifyExpr(n.X, pkgName, declared)
+	case *ast.MapType:
+		n.Key = qualifyExpr(n.Key, pkgName, declared)
+		n.Value = qualifyExpr(n.Value, pkgName, declared)
+	case *ast.ChanType:
+		n.Value = qualifyExpr(n.Value, pkgName, declared)
+	case *ast.Ellipsis:
+		if n.Elt != nil {
+			n.Elt = qualifyExpr(n.Elt, pkgName, declared)
+		}
+	case *ast.ParenExpr:
+		n.X = qualifyExpr(n.X, pkgName, declared)
+	case *ast.IndexExpr:
+		n.X = qualifyExpr(n.X, pkgName, declared)
+		n.Index = qualifyExpr(n.Index, pkgName, declared)
+	case *ast.IndexListExpr:
+		n.X = qualifyExpr(n.X, pkgName, declared)
+		for i := range n.Indices {
+			n.Indices[i] = qualifyExpr(n.Indices[i], pkgName, declared)
+		}
+	case *ast.FuncType:
+		qualifyFields(n.Params)
+		qualifyFields(n.Results)
+	case *ast.StructType:
+		qualifyFields(n.Fields)
+	case *ast.InterfaceType:
+		for _, field := range n.Methods.List {
+			field.Type = qualifyExpr(field.Type, pkgName, declared)
+		}
+	}
+	return expr
+}
+
+// fileImports maps the names file refers to its imports by to their paths.
+// Blank and dot imports cannot qualify types and are left out.
+func fileImports(file *ast.File) map[string]string {
+	imports := make(map[string]string)
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		name := guessPackageName(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name == "_" || name == "." {
+			continue
+		}
+		imports[name] = canonicalImportPath(importPath)
+	}
+	return imports
+}
+
+// canonicalImportPath strips the vendor directory from the import path of a
+// vendored package, like example.com/app/vendor/example.com/dep, which the
+// generated code must import as example.com/dep
+func canonicalImportPath(importPath string) string {
+	if i := strings.LastIndex(importPath, "/vendor/"); i >= 0 {
+		return importPath[i+len("/vendor/"):]
+	}
+	return strings.TrimPrefix(importPath, "vendor/")
+}
+
+// guessPackageName derives a package name from its import path without
+// loading it: the last element, skipping major version suffixes like /v2
+// and gopkg.in style .v3 suffixes
+func guessPackageName(importPath string) string {
+	base := path.Base(importPath)
+	if len(base) > 1 && base[0] == 'v' && strings.Trim(base[1:], "0123456789") == "" {
+		base = path.Base(path.Dir(importPath))
+	}
+	if i := strings.Index(base, ".v"); i > 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// usedImports returns the imports, as import path -> name, of every package
+// qualifying a type anywhere in node, however deeply nested
+func usedImports(node ast.Node, imports map[string]string) map[string]string {
+	used := make(map[string]string)
+	ast.Inspect(node, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if ident, ok := sel.X.(*ast.Ident); ok {
+			if importPath, ok := imports[ident.Name]; ok {
+				used[importPath] = ident.Name
+			}
+		}
+		return false
+	})
+	return used
+}
+
+// isVariadic reports whether the last parameter of funcType is variadic
+func isVariadic(funcType *ast.FuncType) bool {
+	if funcType.Params == nil || len(funcType.Params.List) == 0 {
+		return false
+	}
+	_, ok := funcType.Params.List[len(funcType.Params.List)-1].Type.(*ast.Ellipsis)
+	return ok
+}
+
+// nameParams names the parameters without a usable name, unnamed or _, by
+// their position like arg1, so generated methods can pass them on
+func nameParams(params []Param) []Param {
+	for i, param := range params {
+		if param.Name == "" || param.Name == "_" {
+			params[i].Name = fmt.Sprintf("arg%d", i)
+		}
+	}
+	return params
+}
+
+func extractParams(fieldList *ast.FieldList) []Param {
+	if fieldList == nil {
+		return []Param{}
+	}
+
+	params := make([]Param, 0, fieldList.NumFields())
+	for _, field := range fieldList.List {
+		typeStr := formatNode(field.Type)
+
+		// If there are names, use them
+		if len(field.Names) > 0 {
+			for _, name := range field.Names {
+				params = append(params, Param{Name: name.Name, Type: typeStr})
+			}
+		} else {
+			// For unnamed returns
+			params = append(params, Param{Type: typeStr})
+		}
+	}
+
+	return params
+}
+
+func formatNode(node ast.Expr) string {
+	switch n := node.(type) {
+	case *ast.Ident:
+		return n.Name
+	case *ast.SelectorExpr:
+		return formatNode(n.X) + "." + n.Sel.Name
+	case *ast.StarExpr:
+		return "*" + formatNode(n.X)
+	case *ast.IndexExpr:
+		// generic instantiation with a single type argument
+		return formatNode(n.X) + "[" + formatNode(n.Index) + "]"
+	case *ast.IndexListExpr:
+		// generic instantiation with several type arguments
+		args := make([]string, 0, len(n.Indices))
+		for _, index := range n.Indices {
+			args = append(args, formatNode(index))
+		}
+		return formatNode(n.X) + "[" + strings.Join(args, ", ") + "]"
+	case *ast.ArrayType:
+		if n.Len == nil {
+			return "[]" + formatNode(n.Elt)
+		}
+		return "[" + formatNode(n.Len) + "]" + formatNode(n.Elt)
+	case *ast.MapType:
+		return "map[" + formatNode(n.Key) + "]" + formatNode(n.Value)
+	case *ast.Ellipsis:
+		if n.Elt == nil {
+			// the length of an array like [...]T
+			return "..."
+		}
+		// variadic parameter
+		return "..." + formatNode(n.Elt)
+	case *ast.InterfaceType:
+		return formatInterfaceType(n)
+	case *ast.StructType:
+		return formatStructType(n)
+	case *ast.BinaryExpr:
+		// union of constraint terms, like ~int | ~string
+		return formatNode(n.X) + " " + n.Op.String() + " " + formatNode(n.Y)
+	case *ast.UnaryExpr:
+		// approximation constraint term, like ~int
+		return n.Op.String() + formatNode(n.X)
+	case *ast.ParenExpr:
+		return "(" + formatNode(n.X) + ")"
+	case *ast.FuncType:
+		return "func" + formatFuncParams(n.Params) + formatFuncResults(n.Results)
+	case *ast.BasicLit:
+		return n.Value
+	case *ast.ChanType:
+		switch n.Dir {
+		case ast.SEND:
+			return "chan<- " + formatNode(n.Value)
+		case ast.RECV:
+			return "<-chan " + formatNode(n.Value)
+		default:
+			return "chan " + formatNode(n.Value)
+		}
+	default:
+		return fmt.Sprintf("/* unsupported: %T */", node)
+	}
+}
+
+// formatInterfaceType renders an inline interface, keeping the methods,
+// embedded interfaces and constraint terms it consists of
+func formatInterfaceType(iface *ast.InterfaceType) string {
+	if iface.Methods == nil || len(iface.Methods.List) == 0 {
+		return "interface{}"
+	}
+
+	elems := make([]string, 0, len(iface.Methods.List))
+	for _, field := range iface.Methods.List {
+		funcType, ok := field.Type.(*ast.FuncType)
+		if len(field.Names) == 0 || !ok {
+			// embedded interface or type set, like fmt.Stringer or ~int | ~string
+			elems = append(elems, formatNode(field.Type))
+			continue
+		}
+		for _, name := range field.Names {
+			elems = append(elems, name.Name+formatFuncParams(funcType.Params)+formatFuncResults(funcType.Results))
+		}
+	}
+	return "interface{ " + strings.Join(elems, "; ") + " }"
+}
+
+// formatStructType renders an inline struct, keeping field names, embedded
+// fields and tags, like struct{ Name string `json:"name"`; Port int }
+func formatStructType(st *ast.StructType) string {
+	if st.Fields == nil || len(st.Fields.List) == 0 {
+		return "struct{}"
+	}
+
+	fields := make([]string, 0, len(st.Fields.List))
+	for _, field := range st.Fields.List {
+		names := make([]string, 0, len(field.Names))
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+		elem := formatNode(field.Type)
+		if len(names) > 0 {
+			elem = strings.Join(names, ", ") + " " + elem
+		}
+		if field.Tag != nil {
+			elem += " " + field.Tag.Value
+		}
+		fields = append(fields, elem)
+	}
+	return "struct{ " + strings.Join(fields, "; ") + " }"
+}
+
+// formatField renders a parameter or result of a func type, keeping the names
+// sharing its type together, like a, b int, so nested func types are rendered
+// once rather than once per name
+func formatField(field *ast.Field) string {
+	typeStr := formatNode(field.Type)
+	if len(field.Names) == 0 {
+		return typeStr
+	}
+	names := make([]string, 0, len(field.Names))
+	for _, name := range field.Names {
+		names = append(names, name.Name)
+	}
+	return strings.Join(names, ", ") + " " + typeStr
+}
+
+func formatFuncParams(fields *ast.FieldList) string {
+	if fields == nil {
+		return "()"
+	}
+
+	params := make([]string, 0, fields.NumFields())
+	for _, field := range fields.List {
+		params = append(params, formatField(field))
+	}
+
+	return "(" + strings.Join(params, ", ") + ")"
+}
+
+func formatFuncResults(fields *ast.FieldList) string {
+	if fields == nil || fields.NumFields() == 0 {
+		return ""
+	}
+
+	if fields.NumFields() == 1 && len(fields.List[0].Names) == 0 {
+		return " " + formatNode(fields.List[0].Type)
+	}
+
+	params := make([]string, 0, fields.NumFields())
+	for _, field := range fields.List {
+		params = append(params, formatField(field))
+	}
+
+	return " (" + strings.Join(params, ", ") + ")"
+}
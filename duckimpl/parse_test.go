@@ -0,0 +1,257 @@
+package duckimpl
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// formatsNode reports whether expr is a type, or constraint, that formatNode
+// renders every node of
+func formatsNode(expr ast.Expr) bool {
+	supported := true
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.UnaryExpr:
+			supported = supported && n.Op == token.TILDE
+			return supported
+		case *ast.BinaryExpr:
+			supported = supported && n.Op == token.OR
+			return supported
+		case nil, *ast.Ident, *ast.SelectorExpr, *ast.StarExpr, *ast.IndexExpr, *ast.IndexListExpr,
+			*ast.ArrayType, *ast.MapType, *ast.Ellipsis, *ast.InterfaceType, *ast.StructType,
+			*ast.ParenExpr, *ast.FuncType, *ast.BasicLit, *ast.ChanType,
+			*ast.FieldList, *ast.Field:
+			return true
+		default:
+			supported = false
+			return false
+		}
+	})
+	return supported
+}
+
+func FuzzFormatNode(f *testing.F) {
+	for _, seed := range []string{
+		"int",
+		"*bytes.Buffer",
+		"[]map[string][4]int",
+		"[...]byte",
+		"func(ctx context.Context, args ...any) (n int, err error)",
+		"<-chan chan<- error",
+		"Pair[K, V]",
+		"List[*T]",
+		"interface{ ~int | ~string; String() string }",
+		"struct{ Name string `json:\"name\"`; io.Reader }",
+		"(int)",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		expr, err := parser.ParseExpr(src)
+		if err != nil || !formatsNode(expr) {
+			return
+		}
+
+		got := formatNode(expr)
+		if strings.Contains(got, "unsupported") {
+			t.Fatalf("formatNode(%q) = %q", src, got)
+		}
+
+		// the rendering is Go again, which renders the same
+		reparsed, err := parser.ParseExpr(got)
+		if err != nil {
+			t.Fatalf("formatNode(%q) = %q, which does not parse: %v", src, got, err)
+		}
+		if again := formatNode(reparsed); again != got {
+			t.Fatalf("formatNode(%q) = %q, but %q once reparsed", src, got, again)
+		}
+	})
+}
+
+func FuzzParseInterface(f *testing.F) {
+	for _, seed := range []string{
+		"Read(p []byte) (n int, err error)",
+		"Get(key string) (value []byte, ok bool)\nSet(key string, value []byte)",
+		"Logf(format string, args ...any)",
+		"io.Reader\nClose() error",
+		"Do(func(int) error, chan<- struct{}) <-chan error",
+		"Walk(fn func(path string, err error) error) (err error)",
+		"interface{ Len() int }",
+		"~int | ~string",
+		"Bad(",
+	} {
+		f.Add(seed)
+	}
+	dir := f.TempDir()
+	filename := filepath.Join(dir, "fuzz.go")
+	f.Fuzz(func(t *testing.T, methods string) {
+		src := "package fuzz\n\nimport \"io\"\n\nvar _ io.Reader\n\ntype I interface {\n" + methods + "\n}\n"
+		if err := os.WriteFile(filename, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		iface, err := ParseInterfaceFromFile(filename, "I")
+		if err != nil {
+			return
+		}
+		for _, method := range iface.Methods {
+			if !token.IsIdentifier(method.MethodName) {
+				t.Errorf("method name %q is not an identifier", method.MethodName)
+			}
+			for _, param := range append(method.Params, method.Returns...) {
+				if strings.Contains(param.Type, "unsupported") {
+					t.Errorf("method %s has the parameter type %q", method.MethodName, param.Type)
+				}
+			}
+		}
+	})
+}
+
+// writeFiles writes the files, by slash-separated path, into dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseInterfaceWithASTDottedPath(t *testing.T) {
+	// resolve nothing over the network
+	t.Setenv("GOPROXY", "off")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":                             "module example.com/app\n\ngo 1.22\n",
+		"app.go":                             "package app\n",
+		"store/s.go":                         "package store\n\ntype Store interface {\n\tGet(key string) ([]byte, error)\n}\n",
+		"vendor/github.com/acme/widget/w.go": "package widget\n\ntype Widget interface {\n\tSpin(rpm int)\n}\n",
+	})
+
+	tests := []struct {
+		pkgPath, name, method string
+	}{
+		{"example.com/app/store", "Store", "Get"},
+		{"github.com/acme/widget", "Widget", "Spin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pkgPath, func(t *testing.T) {
+			iface, err := parseInterfaceWithAST(dir, tt.pkgPath, tt.name, tt.pkgPath+"."+tt.name, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if iface.Via != "ast" || iface.PkgPath != tt.pkgPath {
+				t.Errorf("Via, PkgPath = %q, %q, want ast, %q", iface.Via, iface.PkgPath, tt.pkgPath)
+			}
+			if len(iface.Methods) != 1 || iface.Methods[0].MethodName != tt.method {
+				t.Errorf("methods = %v, want %s", iface.Methods, tt.method)
+			}
+		})
+	}
+}
+
+func TestParseInterfaceWithTypesReader(t *testing.T) {
+	iface, err := parseInterfaceWithTypes(fixturesDir, "io", "Reader", "io.Reader", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface.Via != "types" {
+		t.Errorf("Via = %q, want types", iface.Via)
+	}
+	if len(iface.Methods) != 1 {
+		t.Fatalf("methods = %v, want Read", iface.Methods)
+	}
+	read := iface.Methods[0]
+	if got := read.String(); got != "Read(p []byte) (n int, err error)" {
+		t.Errorf("method = %s", got)
+	}
+	if len(read.Imports) != 0 {
+		t.Errorf("builtin types import %v", read.Imports)
+	}
+}
+
+func TestParseInterfaceImportPrefix(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":       "module example.com/m\n\ngo 1.22\n",
+		"foo/foo.go":   "package foo\n\ntype T int\n",
+		"foobar/fb.go": "package foobar\n\ntype T int\n",
+		"iface/i.go":   "package iface\n\nimport (\n\t\"example.com/m/foo\"\n\t\"example.com/m/foobar\"\n)\n\ntype I interface {\n\tFoo(t foo.T)\n\tFooBar() foobar.T\n}\n",
+	})
+
+	iface, err := ParseInterface(filepath.Join(dir, "iface"), "I")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface.Via != "types" {
+		t.Errorf("Via = %q, want types", iface.Via)
+	}
+	want := map[string]map[string]string{
+		"Foo":    {"example.com/m/foo": "foo"},
+		"FooBar": {"example.com/m/foobar": "foobar"},
+	}
+	for _, method := range iface.Methods {
+		if !maps.Equal(method.Imports, want[method.MethodName]) {
+			t.Errorf("%s imports %v, want %v", method.MethodName, method.Imports, want[method.MethodName])
+		}
+	}
+}
+
+func TestDetectPackageNameSkipsTestPackage(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"store.go":      "package store\n",
+		"store_test.go": "package store_test\n",
+		"a_test.go":     "package store_test\n",
+	})
+
+	// map iteration once made the choice random, so ask repeatedly
+	for range 20 {
+		name, err := DetectPackageName(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "store" {
+			t.Fatalf("DetectPackageName = %q, want store", name)
+		}
+	}
+}
+
+func TestParseInterfaceVendored(t *testing.T) {
+	t.Setenv("GOFLAGS", "-mod=vendor")
+	t.Setenv("GOPROXY", "off")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":                             "module example.com/app\n\ngo 1.22\n\nrequire github.com/acme/widget v1.0.0\n",
+		"app.go":                             "package app\n\nimport _ \"github.com/acme/widget\"\n",
+		"vendor/modules.txt":                 "# github.com/acme/widget v1.0.0\n## explicit\ngithub.com/acme/widget\n",
+		"vendor/github.com/acme/widget/w.go": "package widget\n\nimport \"time\"\n\ntype Widget interface {\n\tSpin(rpm int, d time.Duration) error\n}\n",
+	})
+
+	iface, err := ParseInterface(dir, "github.com/acme/widget.Widget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface.Via != "types" || iface.PkgPath != "github.com/acme/widget" {
+		t.Errorf("Via, PkgPath = %q, %q, want types, github.com/acme/widget", iface.Via, iface.PkgPath)
+	}
+	if len(iface.Methods) != 1 {
+		t.Fatalf("methods = %v, want Spin", iface.Methods)
+	}
+	if got := iface.Methods[0].String(); got != "Spin(rpm int, d time.Duration) error" {
+		t.Errorf("method = %s", got)
+	}
+	if want := map[string]string{"time": "time"}; !maps.Equal(iface.Methods[0].Imports, want) {
+		t.Errorf("imports = %v, want %v", iface.Methods[0].Imports, want)
+	}
+}
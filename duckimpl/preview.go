@@ -0,0 +1,113 @@
+package duckimpl
+
+import (
+	"bytes"
+	"go/format"
+	"go/scanner"
+	"go/token"
+	"io"
+	"os"
+)
+
+// ANSI escape sequences used by the preview highlighter
+const (
+	ansiReset   = "\x1b[0m"
+	ansiKeyword = "\x1b[35m"
+	ansiType    = "\x1b[36m"
+	ansiComment = "\x1b[90m"
+	ansiString  = "\x1b[32m"
+)
+
+// predeclaredTypes are the builtin type names highlighted as types
+var predeclaredTypes = map[string]bool{
+	"any": true, "bool": true, "byte": true, "comparable": true,
+	"complex64": true, "complex128": true, "error": true,
+	"float32": true, "float64": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"rune": true, "string": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+}
+
+// Preview writes the formatted generated code to w. When w is a terminal the
+// code is syntax highlighted with ANSI colors, otherwise it is written as is.
+func (g *Generator) Preview(w io.Writer) error {
+	src, err := g.Render()
+	if err != nil {
+		return err
+	}
+
+	// Fall back to the raw template output if it does not format
+	if formatted, err := format.Source(src); err == nil {
+		src = formatted
+	} else {
+		debugLog("could not format generated code: %v\n", err)
+	}
+
+	if !isTerminal(w) {
+		_, err = w.Write(src)
+		return err
+	}
+
+	_, err = w.Write(highlight(src))
+	return err
+}
+
+// isTerminal reports whether w is a character device such as a TTY
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// highlight wraps keywords, types, comments and literals in src with ANSI colors
+func highlight(src []byte) []byte {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+
+	var buf bytes.Buffer
+	last := 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		var color string
+		switch {
+		case tok == token.COMMENT:
+			color = ansiComment
+		case tok.IsKeyword():
+			color = ansiKeyword
+		case tok == token.IDENT && predeclaredTypes[lit]:
+			color = ansiType
+		case tok == token.STRING || tok == token.CHAR:
+			color = ansiString
+		default:
+			continue
+		}
+
+		// Automatically inserted semicolons carry no source text
+		if lit == "" {
+			lit = tok.String()
+		}
+
+		offset := file.Offset(pos)
+		buf.Write(src[last:offset])
+		buf.WriteString(color)
+		buf.WriteString(lit)
+		buf.WriteString(ansiReset)
+		last = offset + len(lit)
+	}
+	buf.Write(src[last:])
+
+	return buf.Bytes()
+}
@@ -0,0 +1,58 @@
+package duckimpl
+
+import (
+	"bytes"
+	"go/format"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreviewPlainWhenNotTerminal(t *testing.T) {
+	g := Generator{
+		StructName:    "ReaderFuncs",
+		InterfaceName: "io.Reader",
+		PackageName:   "fixtures",
+		InterfaceType: "Reader",
+		Methods: []Method{{
+			MethodName: "Read",
+			Params:     []Param{{Name: "p", Type: "[]byte"}},
+			Returns:    []Param{{Name: "n", Type: "int"}, {Name: "err", Type: "error"}},
+		}},
+	}
+	src, err := g.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither a buffer nor a regular file is a terminal
+	var buf bytes.Buffer
+	if err := g.Preview(&buf); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(filepath.Join(t.TempDir(), "preview.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := g.Preview(file); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, got := range map[string][]byte{"buffer": buf.Bytes(), "file": written} {
+		if bytes.Contains(got, []byte("\x1b[")) {
+			t.Errorf("preview to a %s is highlighted:\n%q", name, got)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("preview to a %s = \n%s\nwant\n%s", name, got, want)
+		}
+	}
+}
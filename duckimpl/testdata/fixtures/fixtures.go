@@ -0,0 +1,81 @@
+// Package fixtures declares the interfaces the generator tests implement.
+package fixtures
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+// Item is stored by Store
+type Item struct {
+	Name string
+}
+
+// Store is a local interface using a local type and a stdlib one
+type Store interface {
+	// Get returns the item stored under key
+	Get(ctx context.Context, key string) (Item, error)
+	Put(ctx context.Context, key string, item Item) error
+}
+
+// NamedReadCloser embeds an interface of another package
+type NamedReadCloser interface {
+	io.ReadCloser
+	Name() string
+}
+
+// Logger has a variadic method
+type Logger interface {
+	Logf(format string, args ...any)
+}
+
+// Container is generic
+type Container[K comparable, V any] interface {
+	Get(key K) (V, bool)
+	Put(key K, value V)
+}
+
+// Blank has blank results, which the generated code has to name
+type Blank interface {
+	Blank(n int) (_ int, _ error)
+}
+
+// Shadow names its parameters and results like the decorators' temporaries
+type Shadow interface {
+	Shadow(r1 string) (r0 int, err error)
+}
+
+// NamedReader embeds io.Reader
+type NamedReader interface {
+	io.Reader
+	Name() string
+}
+
+// List is a generic type
+type List[T any] struct {
+	Items []T
+}
+
+// Buffered takes pointers to a type of another package and to an
+// instantiated generic type
+type Buffered interface {
+	Fill(buf *bytes.Buffer, list *List[int]) error
+}
+
+// Scheduler takes generic types instantiated with types of other packages
+type Scheduler interface {
+	Schedule(at map[string]List[time.Time], out *List[*bytes.Buffer]) error
+}
+
+// Caser has parameters whose names differ in the case of a first letter,
+// which may be outside ASCII
+type Caser interface {
+	Mix(p int, P string, ĉu bool)
+}
+
+// Über has a method whose name starts with a letter outside ASCII
+type Über interface {
+	Ärger() error
+}
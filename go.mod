@@ -2,8 +2,13 @@ module github.com/ojxio/duck-impl
 
 go 1.24.1
 
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	golang.org/x/tools v0.31.0
+)
+
 require (
 	golang.org/x/mod v0.24.0 // indirect
 	golang.org/x/sync v0.12.0 // indirect
-	golang.org/x/tools v0.31.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
 )
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ojxio/duck-impl/duckimpl"
+)
+
+// watchQuiet is how long the watched files must stay unchanged after a change
+// before outputFile is regenerated
+const watchQuiet = 300 * time.Millisecond
+
+// watch regenerates outputFile whenever a .go file in dir changes, by running
+// duck-impl again with the same flags but -watch, until interrupted. A burst
+// of changes, like an editor's atomic save, triggers a single regeneration
+// once the files are quiet for watchQuiet, and a failed regeneration is
+// logged without ending the watch.
+func watch(dir, outputFile string) {
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Failed to locate duck-impl: %v", err)
+	}
+	args := withoutFlags(os.Args[1:], "watch")
+
+	regenerate := func() {
+		start := time.Now()
+		cmd := exec.Command(executable, args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("Failed to regenerate %s: %v", outputFile, err)
+			return
+		}
+		log.Printf("Regenerated %s in %v", outputFile, time.Since(start).Round(time.Millisecond))
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatalf("Failed to watch %s: %v", dir, err)
+	}
+	defer watcher.Close()
+	// watching the directory, not its files, sees files replaced by a rename
+	if err := watcher.Add(dir); err != nil {
+		log.Fatalf("Failed to watch %s: %v", dir, err)
+	}
+
+	log.Printf("Watching %s for changes", dir)
+	regenerate()
+
+	output, _ := filepath.Abs(outputFile)
+	quiet := time.NewTimer(watchQuiet)
+	quiet.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// writes of outputFile must not trigger another regeneration
+			path, _ := filepath.Abs(event.Name)
+			if !strings.HasSuffix(path, ".go") || path == output || event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			duckimpl.DebugLog("Change: %s\n", event)
+			quiet.Reset(watchQuiet)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Failed to watch %s: %v", dir, err)
+		case <-quiet.C:
+			regenerate()
+		}
+	}
+}
+
+// withoutFlags returns args without the boolean flags called names, in any
+// of their forms
+func withoutFlags(args []string, names ...string) []string {
+	kept := make([]string, 0, len(args))
+	for _, arg := range args {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && slices.Contains(names, name) {
+			continue
+		}
+		kept = append(kept, arg)
+	}
+	return kept
+}